@@ -7,6 +7,7 @@ import (
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/nerdneilsfield/telenotion-bot/internal/config"
 	"github.com/nerdneilsfield/telenotion-bot/internal/logging"
@@ -59,49 +60,91 @@ func runBot(cmd *cobra.Command, args []string) error {
 		cancel()
 	}()
 
-	runners := make([]func(context.Context) error, 0, 2)
+	runners := make([]supervisedRunner, 0, 2)
 	if cfg.Telegram.Token != "" {
 		telegramRunner, err := session.NewRunner(cfg, logger)
 		if err != nil {
 			return err
 		}
-		runners = append(runners, telegramRunner.Run)
+		defer telegramRunner.Close()
+		runners = append(runners, supervisedRunner{name: "telegram", run: telegramRunner.Run})
 	}
 	if cfg.Discord.Token != "" {
 		discordRunner, err := session.NewDiscordRunner(cfg, logger)
 		if err != nil {
 			return err
 		}
-		runners = append(runners, discordRunner.Run)
+		defer discordRunner.Close()
+		runners = append(runners, supervisedRunner{name: "discord", run: discordRunner.Run})
 	}
 
 	var wg sync.WaitGroup
-	errCh := make(chan error, len(runners))
-
-	for _, run := range runners {
+	for _, runner := range runners {
 		wg.Add(1)
-		go func(run func(context.Context) error) {
+		go func(runner supervisedRunner) {
 			defer wg.Done()
-			if err := run(ctx); err != nil {
-				errCh <- err
-			}
-		}(run)
+			supervise(ctx, runner, logger)
+		}(runner)
 	}
+	wg.Wait()
 
-	go func() {
-		wg.Wait()
-		close(errCh)
-	}()
+	return nil
+}
 
-	select {
-	case err := <-errCh:
-		if err != nil {
-			cancel()
-			return err
+const (
+	restartMinBackoff = time.Second
+	restartMaxBackoff = 5 * time.Minute
+	// healthyRunTime resets the restart backoff once a runner stayed up this
+	// long.
+	healthyRunTime = 10 * time.Minute
+)
+
+type supervisedRunner struct {
+	name string
+	run  func(context.Context) error
+}
+
+// supervise runs runner until ctx is cancelled, restarting it with growing
+// delays whenever it fails or panics so one platform cannot take down the
+// other.
+func supervise(ctx context.Context, runner supervisedRunner, logger *zap.Logger) {
+	backoff := restartMinBackoff
+
+	for {
+		started := time.Now()
+		err := runSafely(ctx, runner.run)
+		if ctx.Err() != nil {
+			return
+		}
+
+		if time.Since(started) >= healthyRunTime {
+			backoff = restartMinBackoff
+		}
+		if err == nil {
+			err = fmt.Errorf("runner stopped unexpectedly")
+		}
+		logger.Error("runner failed, restarting", zap.String("runner", runner.name), zap.Error(err), zap.Duration("retry_in", backoff))
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		backoff *= 2
+		if backoff > restartMaxBackoff {
+			backoff = restartMaxBackoff
 		}
-	case <-ctx.Done():
-		return nil
 	}
+}
 
-	return nil
+func runSafely(ctx context.Context, run func(context.Context) error) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("runner panicked: %v", recovered)
+		}
+	}()
+	return run(ctx)
 }
@@ -2,7 +2,9 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -16,6 +18,8 @@ type Config struct {
 	Notion   Notion   `toml:"notion"`
 	GitHub   GitHub   `toml:"github"`
 	Media    Media    `toml:"media"`
+	Session  Session  `toml:"session"`
+	Outbox   Outbox   `toml:"outbox"`
 	Title    Title    `toml:"title"`
 	Log      Log      `toml:"log"`
 }
@@ -23,8 +27,53 @@ type Config struct {
 type Telegram struct {
 	Token          string  `toml:"token"`
 	AllowedChatIDs []int64 `toml:"allowed_chat_ids"`
+	Mode           string  `toml:"mode"`
+	WebhookListen  string  `toml:"webhook_listen"`
+	WebhookURL     string  `toml:"webhook_url"`
+	WebhookSecret  string  `toml:"webhook_secret"`
+	// APIEndpoint is the base URL of a self-hosted Bot API server, such as
+	// "http://localhost:8081"; empty means the public api.telegram.org.
+	APIEndpoint string `toml:"api_endpoint"`
+	// LocalMode is set when that server runs with --local and hands out
+	// paths on a disk shared with the bot instead of download links.
+	LocalMode bool `toml:"local_mode"`
+	// LocalFilesDir is that server's data directory (its --dir) as the bot
+	// sees it. Local-mode files are only read from inside it.
+	LocalFilesDir string `toml:"local_files_dir"`
+	// GroupTrigger decides which group messages are captured: all of them,
+	// or only those that mention or reply to the bot.
+	GroupTrigger string `toml:"group_trigger"`
+	// PerUserSessions gives every group member sessions of their own.
+	PerUserSessions bool `toml:"per_user_sessions"`
+	// GroupUsers limits who may use the bot in a group, keyed by chat ID.
+	// Groups without an entry are open to all members.
+	GroupUsers map[string][]int64 `toml:"group_users"`
+	// ChannelIDs lists the channels whose posts are mirrored to Notion. The
+	// bot must be an admin there; the list is empty by default.
+	ChannelIDs []int64 `toml:"channel_ids"`
+	// ChannelMode saves every channel post as its own page, or batches the
+	// posts of a day into one page per channel.
+	ChannelMode string `toml:"channel_mode"`
 }
 
+// Telegram update delivery modes.
+const (
+	TelegramModePolling = "polling"
+	TelegramModeWebhook = "webhook"
+)
+
+// Group triggers decide which group messages the bot captures.
+const (
+	GroupTriggerAll     = "all"
+	GroupTriggerMention = "mention"
+)
+
+// Channel modes decide how mirrored channel posts are grouped into pages.
+const (
+	ChannelModePost  = "post"
+	ChannelModeDaily = "daily"
+)
+
 type Discord struct {
 	Token          string   `toml:"token"`
 	AllowedUserIDs []string `toml:"allowed_user_ids"`
@@ -35,6 +84,9 @@ type Notion struct {
 	DatabaseID     string `toml:"database_id"`
 	TitleProperty  string `toml:"title_property"`
 	OriginProperty string `toml:"origin_property"`
+	// SourceProperty optionally names a rich text property that records
+	// where forwarded content came from.
+	SourceProperty string `toml:"source_property"`
 }
 
 type GitHub struct {
@@ -49,11 +101,58 @@ type GitHub struct {
 type Media struct {
 	MaxImageSizeMB    int64    `toml:"max_image_size_mb"`
 	AllowedImageTypes []string `toml:"allowed_image_types"`
+	// MaxFileSizeMB and AllowedFileTypes limit documents, audio and video.
+	// File types accept wildcards such as "video/*" or "*/*".
+	MaxFileSizeMB    int64    `toml:"max_file_size_mb"`
+	AllowedFileTypes []string `toml:"allowed_file_types"`
+	StickerMode      string   `toml:"sticker_mode"`
+}
+
+// Sticker modes decide how stickers are captured.
+const (
+	// StickerModeImage saves the sticker, or a still frame of animated ones.
+	StickerModeImage = "image"
+	// StickerModeEmoji saves only the emoji the sticker stands for.
+	StickerModeEmoji = "emoji"
+)
+
+type Session struct {
+	StoreDir    string        `toml:"store_dir"`
+	IdleTimeout time.Duration `toml:"idle_timeout"`
+	IdleAction  string        `toml:"idle_action"`
+	CaptureMode string        `toml:"capture_mode"`
+	// ReplyContext quotes the message a reply answers before the reply.
+	ReplyContext bool `toml:"reply_context"`
+}
+
+// Capture modes decide where incoming messages go.
+const (
+	// CaptureModeSession buffers messages until /end.
+	CaptureModeSession = "session"
+	// CaptureModeJournal appends every message to the day's page.
+	CaptureModeJournal = "journal"
+	// CaptureModeInstant saves every message as its own page.
+	CaptureModeInstant = "instant"
+)
+
+// Idle actions applied by the session sweeper once idle_timeout elapses.
+const (
+	IdleActionSave    = "save"
+	IdleActionDiscard = "discard"
+	IdleActionRemind  = "remind"
+)
+
+type Outbox struct {
+	MaxAttempts    int           `toml:"max_attempts"`
+	InitialBackoff time.Duration `toml:"initial_backoff"`
+	MaxBackoff     time.Duration `toml:"max_backoff"`
 }
 
 type Title struct {
 	Timezone string `toml:"timezone"`
 	Format   string `toml:"format"`
+	// JournalFormat titles the per-day pages of journal capture mode.
+	JournalFormat string `toml:"journal_format"`
 }
 
 type Log struct {
@@ -63,26 +162,51 @@ type Log struct {
 
 // Environment variable names for config overrides
 const (
-	EnvTelegramToken        = "TELEGRAM_TOKEN"
-	EnvTelegramAllowedIDs   = "TELEGRAM_ALLOWED_CHAT_IDS"
-	EnvDiscordToken         = "DISCORD_TOKEN"
-	EnvDiscordAllowedIDs    = "DISCORD_ALLOWED_USER_IDS"
-	EnvNotionToken          = "NOTION_TOKEN"
-	EnvNotionDatabaseID     = "NOTION_DATABASE_ID"
-	EnvNotionTitleProp      = "NOTION_TITLE_PROPERTY"
-	EnvNotionOriginProp     = "NOTION_ORIGIN_PROPERTY"
-	EnvGitHubToken          = "GITHUB_TOKEN"
-	EnvGitHubRepo           = "GITHUB_REPO"
-	EnvGitHubBranch         = "GITHUB_BRANCH"
-	EnvGitHubTelegramBranch = "GITHUB_TELEGRAM_BRANCH"
-	EnvGitHubDiscordBranch  = "GITHUB_DISCORD_BRANCH"
-	EnvGitHubPathPrefix     = "GITHUB_PATH_PREFIX"
-	EnvMediaMaxImageSizeMB  = "MEDIA_MAX_IMAGE_SIZE_MB"
-	EnvMediaAllowedTypes    = "MEDIA_ALLOWED_IMAGE_TYPES"
-	EnvTitleTimezone        = "TITLE_TIMEZONE"
-	EnvTitleFormat          = "TITLE_FORMAT"
-	EnvLogLevel             = "LOG_LEVEL"
-	EnvLogFile              = "LOG_FILE"
+	EnvTelegramToken         = "TELEGRAM_TOKEN"
+	EnvTelegramAllowedIDs    = "TELEGRAM_ALLOWED_CHAT_IDS"
+	EnvTelegramMode          = "TELEGRAM_MODE"
+	EnvTelegramWebhookListen = "TELEGRAM_WEBHOOK_LISTEN"
+	EnvTelegramWebhookURL    = "TELEGRAM_WEBHOOK_URL"
+	EnvTelegramWebhookSecret = "TELEGRAM_WEBHOOK_SECRET"
+	EnvTelegramGroupTrigger  = "TELEGRAM_GROUP_TRIGGER"
+	EnvTelegramPerUser       = "TELEGRAM_PER_USER_SESSIONS"
+	EnvTelegramGroupUsers    = "TELEGRAM_GROUP_USERS"
+	EnvTelegramChannelIDs    = "TELEGRAM_CHANNEL_IDS"
+	EnvTelegramChannelMode   = "TELEGRAM_CHANNEL_MODE"
+	EnvTelegramAPIEndpoint   = "TELEGRAM_API_ENDPOINT"
+	EnvTelegramLocalMode     = "TELEGRAM_LOCAL_MODE"
+	EnvTelegramLocalFilesDir = "TELEGRAM_LOCAL_FILES_DIR"
+	EnvDiscordToken          = "DISCORD_TOKEN"
+	EnvDiscordAllowedIDs     = "DISCORD_ALLOWED_USER_IDS"
+	EnvNotionToken           = "NOTION_TOKEN"
+	EnvNotionDatabaseID      = "NOTION_DATABASE_ID"
+	EnvNotionTitleProp       = "NOTION_TITLE_PROPERTY"
+	EnvNotionOriginProp      = "NOTION_ORIGIN_PROPERTY"
+	EnvNotionSourceProp      = "NOTION_SOURCE_PROPERTY"
+	EnvGitHubToken           = "GITHUB_TOKEN"
+	EnvGitHubRepo            = "GITHUB_REPO"
+	EnvGitHubBranch          = "GITHUB_BRANCH"
+	EnvGitHubTelegramBranch  = "GITHUB_TELEGRAM_BRANCH"
+	EnvGitHubDiscordBranch   = "GITHUB_DISCORD_BRANCH"
+	EnvGitHubPathPrefix      = "GITHUB_PATH_PREFIX"
+	EnvMediaMaxImageSizeMB   = "MEDIA_MAX_IMAGE_SIZE_MB"
+	EnvMediaAllowedTypes     = "MEDIA_ALLOWED_IMAGE_TYPES"
+	EnvMediaMaxFileSizeMB    = "MEDIA_MAX_FILE_SIZE_MB"
+	EnvMediaAllowedFileTypes = "MEDIA_ALLOWED_FILE_TYPES"
+	EnvMediaStickerMode      = "MEDIA_STICKER_MODE"
+	EnvSessionStoreDir       = "SESSION_STORE_DIR"
+	EnvSessionIdleTimeout    = "SESSION_IDLE_TIMEOUT"
+	EnvSessionIdleAction     = "SESSION_IDLE_ACTION"
+	EnvSessionCaptureMode    = "SESSION_CAPTURE_MODE"
+	EnvSessionReplyContext   = "SESSION_REPLY_CONTEXT"
+	EnvOutboxMaxAttempts     = "OUTBOX_MAX_ATTEMPTS"
+	EnvOutboxInitialBackoff  = "OUTBOX_INITIAL_BACKOFF"
+	EnvOutboxMaxBackoff      = "OUTBOX_MAX_BACKOFF"
+	EnvTitleTimezone         = "TITLE_TIMEZONE"
+	EnvTitleFormat           = "TITLE_FORMAT"
+	EnvTitleJournalFormat    = "TITLE_JOURNAL_FORMAT"
+	EnvLogLevel              = "LOG_LEVEL"
+	EnvLogFile               = "LOG_FILE"
 )
 
 func Load(path string) (*Config, error) {
@@ -132,6 +256,46 @@ func (c *Config) applyEnvOverrides() {
 	if v := os.Getenv(EnvTelegramAllowedIDs); v != "" {
 		c.Telegram.AllowedChatIDs = parseInt64List(v)
 	}
+	if v := os.Getenv(EnvTelegramMode); v != "" {
+		c.Telegram.Mode = v
+	}
+	if v := os.Getenv(EnvTelegramWebhookListen); v != "" {
+		c.Telegram.WebhookListen = v
+	}
+	if v := os.Getenv(EnvTelegramWebhookURL); v != "" {
+		c.Telegram.WebhookURL = v
+	}
+	if v := os.Getenv(EnvTelegramWebhookSecret); v != "" {
+		c.Telegram.WebhookSecret = v
+	}
+	if v := os.Getenv(EnvTelegramAPIEndpoint); v != "" {
+		c.Telegram.APIEndpoint = v
+	}
+	if v := os.Getenv(EnvTelegramLocalMode); v != "" {
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			c.Telegram.LocalMode = parsed
+		}
+	}
+	if v := os.Getenv(EnvTelegramLocalFilesDir); v != "" {
+		c.Telegram.LocalFilesDir = v
+	}
+	if v := os.Getenv(EnvTelegramGroupTrigger); v != "" {
+		c.Telegram.GroupTrigger = v
+	}
+	if v := os.Getenv(EnvTelegramPerUser); v != "" {
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			c.Telegram.PerUserSessions = parsed
+		}
+	}
+	if v := os.Getenv(EnvTelegramGroupUsers); v != "" {
+		c.Telegram.GroupUsers = parseGroupUsers(v)
+	}
+	if v := os.Getenv(EnvTelegramChannelIDs); v != "" {
+		c.Telegram.ChannelIDs = parseInt64List(v)
+	}
+	if v := os.Getenv(EnvTelegramChannelMode); v != "" {
+		c.Telegram.ChannelMode = v
+	}
 
 	if v := os.Getenv(EnvDiscordToken); v != "" {
 		c.Discord.Token = v
@@ -153,6 +317,9 @@ func (c *Config) applyEnvOverrides() {
 	if v := os.Getenv(EnvNotionOriginProp); v != "" {
 		c.Notion.OriginProperty = v
 	}
+	if v := os.Getenv(EnvNotionSourceProp); v != "" {
+		c.Notion.SourceProperty = v
+	}
 
 	// GitHub
 	if v := os.Getenv(EnvGitHubToken); v != "" {
@@ -182,6 +349,55 @@ func (c *Config) applyEnvOverrides() {
 	if v := os.Getenv(EnvMediaAllowedTypes); v != "" {
 		c.Media.AllowedImageTypes = parseStringList(v)
 	}
+	if v := os.Getenv(EnvMediaMaxFileSizeMB); v != "" {
+		if parsed, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+			c.Media.MaxFileSizeMB = parsed
+		}
+	}
+	if v := os.Getenv(EnvMediaAllowedFileTypes); v != "" {
+		c.Media.AllowedFileTypes = parseStringList(v)
+	}
+	if v := os.Getenv(EnvMediaStickerMode); v != "" {
+		c.Media.StickerMode = v
+	}
+
+	// Session
+	if v := os.Getenv(EnvSessionStoreDir); v != "" {
+		c.Session.StoreDir = v
+	}
+	if v := os.Getenv(EnvSessionIdleTimeout); v != "" {
+		if parsed, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+			c.Session.IdleTimeout = parsed
+		}
+	}
+	if v := os.Getenv(EnvSessionIdleAction); v != "" {
+		c.Session.IdleAction = v
+	}
+	if v := os.Getenv(EnvSessionCaptureMode); v != "" {
+		c.Session.CaptureMode = v
+	}
+	if v := os.Getenv(EnvSessionReplyContext); v != "" {
+		if parsed, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			c.Session.ReplyContext = parsed
+		}
+	}
+
+	// Outbox
+	if v := os.Getenv(EnvOutboxMaxAttempts); v != "" {
+		if parsed, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			c.Outbox.MaxAttempts = parsed
+		}
+	}
+	if v := os.Getenv(EnvOutboxInitialBackoff); v != "" {
+		if parsed, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+			c.Outbox.InitialBackoff = parsed
+		}
+	}
+	if v := os.Getenv(EnvOutboxMaxBackoff); v != "" {
+		if parsed, err := time.ParseDuration(strings.TrimSpace(v)); err == nil {
+			c.Outbox.MaxBackoff = parsed
+		}
+	}
 
 	// Title
 	if v := os.Getenv(EnvTitleTimezone); v != "" {
@@ -190,6 +406,9 @@ func (c *Config) applyEnvOverrides() {
 	if v := os.Getenv(EnvTitleFormat); v != "" {
 		c.Title.Format = v
 	}
+	if v := os.Getenv(EnvTitleJournalFormat); v != "" {
+		c.Title.JournalFormat = v
+	}
 
 	// Log
 	if v := os.Getenv(EnvLogLevel); v != "" {
@@ -216,6 +435,20 @@ func parseInt64List(s string) []int64 {
 	return result
 }
 
+// parseGroupUsers parses "chat=user,user;chat=user" into group_users.
+func parseGroupUsers(s string) map[string][]int64 {
+	result := make(map[string][]int64)
+	for _, entry := range strings.Split(s, ";") {
+		chat, users, ok := strings.Cut(entry, "=")
+		chat = strings.TrimSpace(chat)
+		if !ok || chat == "" {
+			continue
+		}
+		result[chat] = parseInt64List(users)
+	}
+	return result
+}
+
 func parseStringList(s string) []string {
 	if s == "" {
 		return nil
@@ -235,6 +468,10 @@ func defaultMediaAllowedTypes() []string {
 	return []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
 }
 
+func defaultMediaAllowedFileTypes() []string {
+	return []string{"*/*"}
+}
+
 func normalizeMediaTypes(types []string) []string {
 	if len(types) == 0 {
 		return nil
@@ -251,12 +488,31 @@ func normalizeMediaTypes(types []string) []string {
 }
 
 func (c *Config) Normalize() {
+	c.Telegram.Mode = strings.ToLower(strings.TrimSpace(c.Telegram.Mode))
+	if c.Telegram.Mode == "" {
+		c.Telegram.Mode = TelegramModePolling
+	}
+	if c.Telegram.Mode == TelegramModeWebhook && c.Telegram.WebhookListen == "" {
+		c.Telegram.WebhookListen = ":8080"
+	}
+	c.Telegram.APIEndpoint = strings.TrimSuffix(strings.TrimSpace(c.Telegram.APIEndpoint), "/")
+	c.Telegram.GroupTrigger = strings.ToLower(strings.TrimSpace(c.Telegram.GroupTrigger))
+	if c.Telegram.GroupTrigger == "" {
+		c.Telegram.GroupTrigger = GroupTriggerAll
+	}
+	c.Telegram.ChannelMode = strings.ToLower(strings.TrimSpace(c.Telegram.ChannelMode))
+	if c.Telegram.ChannelMode == "" {
+		c.Telegram.ChannelMode = ChannelModePost
+	}
 	if c.Title.Timezone == "" {
 		c.Title.Timezone = "Asia/Shanghai"
 	}
 	if c.Title.Format == "" {
 		c.Title.Format = "2006-01-02 15:04"
 	}
+	if c.Title.JournalFormat == "" {
+		c.Title.JournalFormat = "2006-01-02"
+	}
 	if c.Notion.TitleProperty == "" {
 		c.Notion.TitleProperty = "Name"
 	}
@@ -270,6 +526,34 @@ func (c *Config) Normalize() {
 	if len(c.Media.AllowedImageTypes) == 0 {
 		c.Media.AllowedImageTypes = defaultMediaAllowedTypes()
 	}
+	if c.Media.MaxFileSizeMB <= 0 {
+		c.Media.MaxFileSizeMB = 20
+	}
+	c.Media.AllowedFileTypes = normalizeMediaTypes(c.Media.AllowedFileTypes)
+	if len(c.Media.AllowedFileTypes) == 0 {
+		c.Media.AllowedFileTypes = defaultMediaAllowedFileTypes()
+	}
+	c.Media.StickerMode = strings.ToLower(strings.TrimSpace(c.Media.StickerMode))
+	if c.Media.StickerMode == "" {
+		c.Media.StickerMode = StickerModeImage
+	}
+	c.Session.IdleAction = strings.ToLower(strings.TrimSpace(c.Session.IdleAction))
+	if c.Session.IdleAction == "" {
+		c.Session.IdleAction = IdleActionSave
+	}
+	c.Session.CaptureMode = strings.ToLower(strings.TrimSpace(c.Session.CaptureMode))
+	if c.Session.CaptureMode == "" {
+		c.Session.CaptureMode = CaptureModeSession
+	}
+	if c.Outbox.MaxAttempts <= 0 {
+		c.Outbox.MaxAttempts = 10
+	}
+	if c.Outbox.InitialBackoff <= 0 {
+		c.Outbox.InitialBackoff = 30 * time.Second
+	}
+	if c.Outbox.MaxBackoff <= 0 {
+		c.Outbox.MaxBackoff = 30 * time.Minute
+	}
 	if c.Log.Level == "" {
 		c.Log.Level = "info"
 	}
@@ -304,6 +588,36 @@ func (c *Config) Validate() error {
 		if len(c.Telegram.AllowedChatIDs) == 0 {
 			return fmt.Errorf("telegram.allowed_chat_ids is required")
 		}
+		switch c.Telegram.Mode {
+		case "", TelegramModePolling:
+		case TelegramModeWebhook:
+			if err := validateWebhook(c.Telegram); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("telegram.mode must be one of polling, webhook")
+		}
+		if err := validateAPIEndpoint(c.Telegram); err != nil {
+			return err
+		}
+		if c.Telegram.APIEndpoint == "" && c.Media.MaxFileSizeMB > publicBotAPIFileLimitMB {
+			return fmt.Errorf("media.max_file_size_mb cannot exceed %d without telegram.api_endpoint", publicBotAPIFileLimitMB)
+		}
+		switch c.Telegram.GroupTrigger {
+		case "", GroupTriggerAll, GroupTriggerMention:
+		default:
+			return fmt.Errorf("telegram.group_trigger must be one of all, mention")
+		}
+		for chat := range c.Telegram.GroupUsers {
+			if _, err := strconv.ParseInt(chat, 10, 64); err != nil {
+				return fmt.Errorf("telegram.group_users has invalid chat ID %q", chat)
+			}
+		}
+		switch c.Telegram.ChannelMode {
+		case "", ChannelModePost, ChannelModeDaily:
+		default:
+			return fmt.Errorf("telegram.channel_mode must be one of post, daily")
+		}
 	}
 
 	if discordEnabled {
@@ -337,6 +651,23 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("title.timezone is invalid: %w", err)
 	}
 
+	if c.Session.IdleTimeout < 0 {
+		return fmt.Errorf("session.idle_timeout must not be negative")
+	}
+	switch c.Session.IdleAction {
+	case "", IdleActionSave, IdleActionDiscard, IdleActionRemind:
+	default:
+		return fmt.Errorf("session.idle_action must be one of save, discard, remind")
+	}
+	switch c.Session.CaptureMode {
+	case "", CaptureModeSession, CaptureModeJournal, CaptureModeInstant:
+	default:
+		return fmt.Errorf("session.capture_mode must be one of session, journal, instant")
+	}
+	if c.Outbox.MaxBackoff > 0 && c.Outbox.InitialBackoff > c.Outbox.MaxBackoff {
+		return fmt.Errorf("outbox.initial_backoff must not exceed outbox.max_backoff")
+	}
+
 	skipMediaValidation := c.Media.MaxImageSizeMB == 0 && len(c.Media.AllowedImageTypes) == 0
 	if !skipMediaValidation {
 		if c.Media.MaxImageSizeMB <= 0 {
@@ -346,7 +677,80 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("media.allowed_image_types is required")
 		}
 	}
+	for _, fileType := range c.Media.AllowedFileTypes {
+		if !mediaTypeRe.MatchString(fileType) {
+			return fmt.Errorf("media.allowed_file_types has invalid type %q", fileType)
+		}
+	}
+	switch c.Media.StickerMode {
+	case "", StickerModeImage, StickerModeEmoji:
+	default:
+		return fmt.Errorf("media.sticker_mode must be one of image, emoji")
+	}
+
+	return nil
+}
+
+var mediaTypeRe = regexp.MustCompile(`^(\*/\*|[a-z0-9][a-z0-9.+-]*/(\*|[a-z0-9][a-z0-9.+-]*))$`)
+
+var webhookSecretRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
+
+// UserAllowed reports whether userID may use the bot in chatID.
+func (t Telegram) UserAllowed(chatID int64, userID int64) bool {
+	users, ok := t.GroupUsers[strconv.FormatInt(chatID, 10)]
+	if !ok {
+		return true
+	}
+	for _, id := range users {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ChannelAllowed reports whether posts of chatID are mirrored.
+func (t Telegram) ChannelAllowed(chatID int64) bool {
+	for _, id := range t.ChannelIDs {
+		if id == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// publicBotAPIFileLimitMB is the largest file the public Bot API lets bots
+// download.
+const publicBotAPIFileLimitMB = 20
 
+func validateAPIEndpoint(t Telegram) error {
+	if t.APIEndpoint == "" {
+		if t.LocalMode {
+			return fmt.Errorf("telegram.local_mode requires telegram.api_endpoint")
+		}
+		return nil
+	}
+	parsed, err := url.Parse(t.APIEndpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("telegram.api_endpoint must be an http or https URL")
+	}
+	if t.LocalMode && t.LocalFilesDir == "" {
+		return fmt.Errorf("telegram.local_files_dir is required in local mode")
+	}
+	return nil
+}
+
+func validateWebhook(t Telegram) error {
+	if t.WebhookURL == "" {
+		return fmt.Errorf("telegram.webhook_url is required in webhook mode")
+	}
+	parsed, err := url.Parse(t.WebhookURL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return fmt.Errorf("telegram.webhook_url must be an https URL")
+	}
+	if !webhookSecretRe.MatchString(t.WebhookSecret) {
+		return fmt.Errorf("telegram.webhook_secret must be 1-256 characters of A-Z, a-z, 0-9, _ or -")
+	}
 	return nil
 }
 
@@ -357,3 +761,8 @@ func (t Title) Location() (*time.Location, error) {
 func (t Title) FormatTime(loc *time.Location) string {
 	return time.Now().In(loc).Format(t.Format)
 }
+
+// FormatJournal returns the title of the current day's journal page.
+func (t Title) FormatJournal(loc *time.Location) string {
+	return time.Now().In(loc).Format(t.JournalFormat)
+}
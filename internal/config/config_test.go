@@ -136,6 +136,42 @@ func TestNormalize(t *testing.T) {
 	if len(cfg.Media.AllowedImageTypes) == 0 {
 		t.Error("Media.AllowedImageTypes should not be empty")
 	}
+	if cfg.Media.MaxFileSizeMB != 20 {
+		t.Errorf("Media.MaxFileSizeMB = %d, want %d", cfg.Media.MaxFileSizeMB, 20)
+	}
+	if len(cfg.Media.AllowedFileTypes) != 1 || cfg.Media.AllowedFileTypes[0] != "*/*" {
+		t.Errorf("Media.AllowedFileTypes = %v, want [*/*]", cfg.Media.AllowedFileTypes)
+	}
+	if cfg.Media.StickerMode != StickerModeImage {
+		t.Errorf("Media.StickerMode = %q, want %q", cfg.Media.StickerMode, StickerModeImage)
+	}
+	if cfg.Session.IdleAction != IdleActionSave {
+		t.Errorf("Session.IdleAction = %q, want %q", cfg.Session.IdleAction, IdleActionSave)
+	}
+	if cfg.Telegram.Mode != TelegramModePolling {
+		t.Errorf("Telegram.Mode = %q, want %q", cfg.Telegram.Mode, TelegramModePolling)
+	}
+	if cfg.Telegram.GroupTrigger != GroupTriggerAll {
+		t.Errorf("Telegram.GroupTrigger = %q, want %q", cfg.Telegram.GroupTrigger, GroupTriggerAll)
+	}
+	if cfg.Telegram.ChannelMode != ChannelModePost {
+		t.Errorf("Telegram.ChannelMode = %q, want %q", cfg.Telegram.ChannelMode, ChannelModePost)
+	}
+	if cfg.Session.CaptureMode != CaptureModeSession {
+		t.Errorf("Session.CaptureMode = %q, want %q", cfg.Session.CaptureMode, CaptureModeSession)
+	}
+	if cfg.Title.JournalFormat != "2006-01-02" {
+		t.Errorf("Title.JournalFormat = %q, want %q", cfg.Title.JournalFormat, "2006-01-02")
+	}
+	if cfg.Outbox.MaxAttempts != 10 {
+		t.Errorf("Outbox.MaxAttempts = %d, want %d", cfg.Outbox.MaxAttempts, 10)
+	}
+	if cfg.Outbox.InitialBackoff != 30*time.Second {
+		t.Errorf("Outbox.InitialBackoff = %v, want %v", cfg.Outbox.InitialBackoff, 30*time.Second)
+	}
+	if cfg.Outbox.MaxBackoff != 30*time.Minute {
+		t.Errorf("Outbox.MaxBackoff = %v, want %v", cfg.Outbox.MaxBackoff, 30*time.Minute)
+	}
 }
 
 func TestGitHubBranchFallback(t *testing.T) {
@@ -282,6 +318,152 @@ func TestValidate_MissingToken(t *testing.T) {
 			},
 			wantErr: "title.timezone is invalid: unknown time zone Invalid/Timezone",
 		},
+		{
+			name: "invalid idle action",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Session:  Session{IdleTimeout: time.Hour, IdleAction: "archive"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "session.idle_action must be one of save, discard, remind",
+		},
+		{
+			name: "webhook without url",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}, Mode: TelegramModeWebhook, WebhookSecret: "secret"},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "telegram.webhook_url is required in webhook mode",
+		},
+		{
+			name: "webhook with invalid secret",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}, Mode: TelegramModeWebhook, WebhookURL: "https://bot.example.com/telegram", WebhookSecret: "not secret!"},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "telegram.webhook_secret must be 1-256 characters of A-Z, a-z, 0-9, _ or -",
+		},
+		{
+			name: "invalid file type",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Media:    Media{AllowedFileTypes: []string{"pdf"}},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: `media.allowed_file_types has invalid type "pdf"`,
+		},
+		{
+			name: "invalid sticker mode",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Media:    Media{StickerMode: "gif"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "media.sticker_mode must be one of image, emoji",
+		},
+		{
+			name: "invalid group trigger",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}, GroupTrigger: "reply"},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "telegram.group_trigger must be one of all, mention",
+		},
+		{
+			name: "invalid group users chat",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}, GroupUsers: map[string][]int64{"team": {1}}},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: `telegram.group_users has invalid chat ID "team"`,
+		},
+		{
+			name: "invalid api endpoint",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}, APIEndpoint: "localhost:8081"},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "telegram.api_endpoint must be an http or https URL",
+		},
+		{
+			name: "local mode without api endpoint",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}, LocalMode: true},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "telegram.local_mode requires telegram.api_endpoint",
+		},
+		{
+			name: "file limit above public bot api",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Media:    Media{MaxFileSizeMB: 50},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "media.max_file_size_mb cannot exceed 20 without telegram.api_endpoint",
+		},
+		{
+			name: "local mode without files dir",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}, APIEndpoint: "http://localhost:8081", LocalMode: true},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "telegram.local_files_dir is required in local mode",
+		},
+		{
+			name: "invalid channel mode",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}, ChannelMode: "weekly"},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "telegram.channel_mode must be one of post, daily",
+		},
+		{
+			name: "invalid capture mode",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Session:  Session{CaptureMode: "stream"},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "session.capture_mode must be one of session, journal, instant",
+		},
+		{
+			name: "outbox backoff inverted",
+			cfg: Config{
+				Telegram: Telegram{Token: "token", AllowedChatIDs: []int64{1}},
+				Notion:   Notion{Token: "token", DatabaseID: "id"},
+				GitHub:   GitHub{Token: "token", Repo: "repo", Branch: "main"},
+				Outbox:   Outbox{InitialBackoff: time.Hour, MaxBackoff: time.Minute},
+				Title:    Title{Timezone: "UTC"},
+			},
+			wantErr: "outbox.initial_backoff must not exceed outbox.max_backoff",
+		},
 	}
 
 	for _, tt := range tests {
@@ -398,6 +580,7 @@ func TestApplyEnvOverrides(t *testing.T) {
 	os.Setenv(EnvNotionDatabaseID, "env-database-id")
 	os.Setenv(EnvNotionTitleProp, "Title")
 	os.Setenv(EnvNotionOriginProp, "Origin")
+	os.Setenv(EnvNotionSourceProp, "Source")
 	os.Setenv(EnvGitHubToken, "env-github-token")
 	os.Setenv(EnvGitHubRepo, "env-owner/env-repo")
 	os.Setenv(EnvGitHubBranch, "develop")
@@ -406,6 +589,13 @@ func TestApplyEnvOverrides(t *testing.T) {
 	os.Setenv(EnvGitHubPathPrefix, "env-images/")
 	os.Setenv(EnvMediaMaxImageSizeMB, "25")
 	os.Setenv(EnvMediaAllowedTypes, "image/jpeg,image/png")
+	os.Setenv(EnvSessionStoreDir, "/var/lib/telenotion")
+	os.Setenv(EnvSessionIdleTimeout, "2h")
+	os.Setenv(EnvSessionIdleAction, "remind")
+	os.Setenv(EnvSessionCaptureMode, "journal")
+	os.Setenv(EnvSessionReplyContext, "true")
+	os.Setenv(EnvOutboxMaxAttempts, "5")
+	os.Setenv(EnvOutboxMaxBackoff, "1h")
 	os.Setenv(EnvTitleTimezone, "America/New_York")
 	os.Setenv(EnvTitleFormat, "2006-01-02")
 	os.Setenv(EnvLogLevel, "debug")
@@ -421,6 +611,7 @@ func TestApplyEnvOverrides(t *testing.T) {
 		os.Unsetenv(EnvNotionDatabaseID)
 		os.Unsetenv(EnvNotionTitleProp)
 		os.Unsetenv(EnvNotionOriginProp)
+		os.Unsetenv(EnvNotionSourceProp)
 		os.Unsetenv(EnvGitHubToken)
 		os.Unsetenv(EnvGitHubRepo)
 		os.Unsetenv(EnvGitHubBranch)
@@ -429,6 +620,13 @@ func TestApplyEnvOverrides(t *testing.T) {
 		os.Unsetenv(EnvGitHubPathPrefix)
 		os.Unsetenv(EnvMediaMaxImageSizeMB)
 		os.Unsetenv(EnvMediaAllowedTypes)
+		os.Unsetenv(EnvSessionStoreDir)
+		os.Unsetenv(EnvSessionIdleTimeout)
+		os.Unsetenv(EnvSessionIdleAction)
+		os.Unsetenv(EnvSessionCaptureMode)
+		os.Unsetenv(EnvSessionReplyContext)
+		os.Unsetenv(EnvOutboxMaxAttempts)
+		os.Unsetenv(EnvOutboxMaxBackoff)
 		os.Unsetenv(EnvTitleTimezone)
 		os.Unsetenv(EnvTitleFormat)
 		os.Unsetenv(EnvLogLevel)
@@ -467,6 +665,9 @@ func TestApplyEnvOverrides(t *testing.T) {
 	if cfg.Notion.OriginProperty != "Origin" {
 		t.Errorf("OriginProperty = %q, want %q", cfg.Notion.OriginProperty, "Origin")
 	}
+	if cfg.Notion.SourceProperty != "Source" {
+		t.Errorf("SourceProperty = %q, want %q", cfg.Notion.SourceProperty, "Source")
+	}
 	if cfg.GitHub.Token != "env-github-token" {
 		t.Errorf("GitHub.Token = %q, want %q", cfg.GitHub.Token, "env-github-token")
 	}
@@ -488,6 +689,27 @@ func TestApplyEnvOverrides(t *testing.T) {
 	if len(cfg.Media.AllowedImageTypes) != 2 {
 		t.Errorf("Media.AllowedImageTypes length = %d, want %d", len(cfg.Media.AllowedImageTypes), 2)
 	}
+	if cfg.Session.StoreDir != "/var/lib/telenotion" {
+		t.Errorf("Session.StoreDir = %q, want %q", cfg.Session.StoreDir, "/var/lib/telenotion")
+	}
+	if cfg.Session.IdleTimeout != 2*time.Hour {
+		t.Errorf("Session.IdleTimeout = %v, want %v", cfg.Session.IdleTimeout, 2*time.Hour)
+	}
+	if cfg.Session.IdleAction != "remind" {
+		t.Errorf("Session.IdleAction = %q, want %q", cfg.Session.IdleAction, "remind")
+	}
+	if cfg.Session.CaptureMode != CaptureModeJournal {
+		t.Errorf("Session.CaptureMode = %q, want %q", cfg.Session.CaptureMode, CaptureModeJournal)
+	}
+	if !cfg.Session.ReplyContext {
+		t.Error("Session.ReplyContext = false, want true")
+	}
+	if cfg.Outbox.MaxAttempts != 5 {
+		t.Errorf("Outbox.MaxAttempts = %d, want %d", cfg.Outbox.MaxAttempts, 5)
+	}
+	if cfg.Outbox.MaxBackoff != time.Hour {
+		t.Errorf("Outbox.MaxBackoff = %v, want %v", cfg.Outbox.MaxBackoff, time.Hour)
+	}
 	if cfg.Title.Timezone != "America/New_York" {
 		t.Errorf("Title.Timezone = %q, want %q", cfg.Title.Timezone, "America/New_York")
 	}
@@ -609,3 +831,20 @@ func TestLoadFromEnvDiscordOnly(t *testing.T) {
 		t.Errorf("OriginProperty = %q, want %q", cfg.Notion.OriginProperty, "Origin")
 	}
 }
+
+func TestTelegramUserAllowed(t *testing.T) {
+	telegram := Telegram{GroupUsers: parseGroupUsers("-100123=1, 2; -100456=")}
+
+	if !telegram.UserAllowed(-100123, 2) {
+		t.Error("expected listed user to be allowed")
+	}
+	if telegram.UserAllowed(-100123, 3) {
+		t.Error("expected unlisted user to be rejected")
+	}
+	if telegram.UserAllowed(-100456, 1) {
+		t.Error("expected empty allowlist to reject everyone")
+	}
+	if !telegram.UserAllowed(-100789, 3) {
+		t.Error("expected chats without an allowlist to be open")
+	}
+}
@@ -1,6 +1,7 @@
 package discordclient
 
 import (
+	"encoding/json"
 	"regexp"
 	"strings"
 
@@ -141,3 +142,26 @@ func plainRichText(text string) notionapi.RichText {
 		Text: &notionapi.Text{Content: text},
 	}
 }
+
+// AttachmentDescriptions returns the alt text of each attachment in a raw
+// message payload, keyed by attachment ID. discordgo does not decode the
+// description field, so it is read from the gateway event directly.
+func AttachmentDescriptions(raw json.RawMessage) map[string]string {
+	var payload struct {
+		Attachments []struct {
+			ID          string `json:"id"`
+			Description string `json:"description"`
+		} `json:"attachments"`
+	}
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil
+	}
+
+	descriptions := make(map[string]string, len(payload.Attachments))
+	for _, attachment := range payload.Attachments {
+		if description := strings.TrimSpace(attachment.Description); description != "" {
+			descriptions[attachment.ID] = description
+		}
+	}
+	return descriptions
+}
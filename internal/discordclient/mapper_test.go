@@ -38,3 +38,15 @@ func TestContentToRichTextLink(t *testing.T) {
 		t.Fatalf("unexpected link url: %q", result[0].Text.Link.Url)
 	}
 }
+
+func TestAttachmentDescriptions(t *testing.T) {
+	raw := []byte(`{"id":"1","attachments":[{"id":"a1","filename":"cat.png","description":" a sleepy cat "},{"id":"a2","filename":"dog.png"}]}`)
+
+	descriptions := AttachmentDescriptions(raw)
+	if len(descriptions) != 1 || descriptions["a1"] != "a sleepy cat" {
+		t.Fatalf("unexpected descriptions %v", descriptions)
+	}
+	if AttachmentDescriptions([]byte(`not json`)) != nil {
+		t.Fatalf("expected nil for invalid payload")
+	}
+}
@@ -40,6 +40,15 @@ func NewClient(token, repo, branch, pathPrefix string) *Client {
 }
 
 func (c *Client) UploadImage(ctx context.Context, data []byte, extension string) (string, error) {
+	return c.upload(ctx, data, extension, "upload image")
+}
+
+// UploadFile stores a document, audio or video file and returns its raw URL.
+func (c *Client) UploadFile(ctx context.Context, data []byte, extension string) (string, error) {
+	return c.upload(ctx, data, extension, "upload file")
+}
+
+func (c *Client) upload(ctx context.Context, data []byte, extension string, message string) (string, error) {
 	if c.repo == "" || c.branch == "" {
 		return "", fmt.Errorf("github repo and branch are required")
 	}
@@ -52,7 +61,7 @@ func (c *Client) UploadImage(ctx context.Context, data []byte, extension string)
 	url := fmt.Sprintf("https://api.github.com/repos/%s/contents/%s", c.repo, path)
 
 	payload := createFileRequest{
-		Message: "upload image",
+		Message: message,
 		Content: base64.StdEncoding.EncodeToString(data),
 		Branch:  c.branch,
 	}
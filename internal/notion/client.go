@@ -2,50 +2,223 @@ package notion
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/jomei/notionapi"
 )
 
+// childrenLimit is the most child blocks Notion accepts in a single request.
+const childrenLimit = 100
+
+var pageIDRe = regexp.MustCompile(`(?i)([0-9a-f]{8})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{4})-?([0-9a-f]{12})$`)
+
 type Client struct {
 	client *notionapi.Client
 }
 
-func NewClient(token string) *Client {
-	return &Client{client: notionapi.NewClient(notionapi.Token(token))}
+func NewClient(token string, options ...notionapi.ClientOption) *Client {
+	return &Client{client: notionapi.NewClient(notionapi.Token(token), options...)}
 }
 
-func (c *Client) CreatePage(ctx context.Context, databaseID string, titleProperty string, originProperty string, title string, origin string, children []notionapi.Block) (string, error) {
-	var lastErr error
-	for attempt := 0; attempt < 3; attempt++ {
-		properties := notionapi.Properties{
-			titleProperty: notionapi.TitleProperty{
-				Title: []notionapi.RichText{{Text: &notionapi.Text{Content: title}}},
-			},
-		}
-		if originProperty != "" && origin != "" {
-			properties[originProperty] = notionapi.SelectProperty{
-				Select: notionapi.Option{Name: origin},
-			}
+// PartialWriteError reports a write that stopped partway: the page exists
+// and the first Written blocks are on it. Retrying should append the rest to
+// PageID rather than start over.
+type PartialWriteError struct {
+	PageID  string
+	Written int
+	Err     error
+}
+
+func (e *PartialWriteError) Error() string {
+	return fmt.Sprintf("notion wrote %d blocks to page %s before failing: %v", e.Written, e.PageID, e.Err)
+}
+
+func (e *PartialWriteError) Unwrap() error {
+	return e.Err
+}
+
+// Page holds the database properties of a new page.
+type Page struct {
+	TitleProperty  string
+	Title          string
+	OriginProperty string
+	Origin         string
+	// SourceProperty is an optional rich text property recording where the
+	// content was forwarded from.
+	SourceProperty string
+	Source         []notionapi.RichText
+}
+
+func (p Page) properties() notionapi.Properties {
+	properties := notionapi.Properties{
+		p.TitleProperty: notionapi.TitleProperty{
+			Title: []notionapi.RichText{{Text: &notionapi.Text{Content: p.Title}}},
+		},
+	}
+	if p.OriginProperty != "" && p.Origin != "" {
+		properties[p.OriginProperty] = notionapi.SelectProperty{
+			Select: notionapi.Option{Name: p.Origin},
 		}
+	}
+	if p.SourceProperty != "" && len(p.Source) > 0 {
+		properties[p.SourceProperty] = notionapi.RichTextProperty{RichText: p.Source}
+	}
+	return properties
+}
+
+// CreatedPage identifies a page the client created.
+type CreatedPage struct {
+	ID  string
+	URL string
+}
+
+// CreatePage adds a page to the database and returns its ID and browser link.
+// When the page was created but appending the children past the first batch
+// failed, the page is returned along with a *PartialWriteError.
+func (c *Client) CreatePage(ctx context.Context, databaseID string, page Page, children []notionapi.Block) (CreatedPage, error) {
+	first, rest := splitChildren(children)
+	properties := page.properties()
 
+	var created CreatedPage
+	err := withRetry(ctx, func() error {
 		page, err := c.client.Page.Create(ctx, &notionapi.PageCreateRequest{
 			Parent:     notionapi.Parent{Type: notionapi.ParentTypeDatabaseID, DatabaseID: notionapi.DatabaseID(databaseID)},
 			Properties: properties,
-			Children:   children,
+			Children:   first,
 		})
-		if err == nil {
-			return string(page.ID), nil
+		if err != nil {
+			return err
+		}
+		created = CreatedPage{ID: string(page.ID), URL: page.URL}
+		return nil
+	})
+	if err != nil {
+		return CreatedPage{}, fmt.Errorf("notion create page failed: %w", err)
+	}
+	if created.URL == "" {
+		created.URL = PageURL(created.ID)
+	}
+
+	if len(rest) > 0 {
+		if err := c.AppendBlocks(ctx, created.ID, rest); err != nil {
+			var partial *PartialWriteError
+			if errors.As(err, &partial) {
+				partial.Written += len(first)
+				return created, partial
+			}
+			return created, &PartialWriteError{PageID: created.ID, Written: len(first), Err: err}
+		}
+	}
+	return created, nil
+}
+
+// FindOrCreatePage returns the first database page whose title equals
+// page.Title, creating an empty one when none exists.
+func (c *Client) FindOrCreatePage(ctx context.Context, databaseID string, page Page) (string, error) {
+	var pageID string
+	err := withRetry(ctx, func() error {
+		response, err := c.client.Database.Query(ctx, notionapi.DatabaseID(databaseID), &notionapi.DatabaseQueryRequest{
+			Filter: notionapi.PropertyFilter{
+				Property: page.TitleProperty,
+				RichText: &notionapi.TextFilterCondition{Equals: page.Title},
+			},
+			PageSize: 1,
+		})
+		if err != nil {
+			return err
+		}
+		if len(response.Results) > 0 {
+			pageID = string(response.Results[0].ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("notion query database failed: %w", err)
+	}
+	if pageID != "" {
+		return pageID, nil
+	}
+
+	created, err := c.CreatePage(ctx, databaseID, page, nil)
+	return created.ID, err
+}
+
+// AppendBlocks adds children to the end of an existing page, in batches that
+// fit Notion's per-request limit. A failure after the first batch is a
+// *PartialWriteError.
+func (c *Client) AppendBlocks(ctx context.Context, pageID string, children []notionapi.Block) error {
+	written := 0
+	for len(children) > 0 {
+		batch, rest := splitChildren(children)
+
+		err := withRetry(ctx, func() error {
+			_, err := c.client.Block.AppendChildren(ctx, notionapi.BlockID(pageID), &notionapi.AppendBlockChildrenRequest{Children: batch})
+			return err
+		})
+		if err != nil {
+			err = fmt.Errorf("notion append blocks failed: %w", err)
+			if written > 0 {
+				return &PartialWriteError{PageID: pageID, Written: written, Err: err}
+			}
+			return err
+		}
+
+		written += len(batch)
+		children = rest
+	}
+
+	return nil
+}
+
+// ParsePageID extracts a page ID from a bare ID or a Notion page link and
+// returns it in dashed UUID form.
+func ParsePageID(value string) (string, error) {
+	candidate := strings.TrimSpace(value)
+	if parsed, err := url.Parse(candidate); err == nil && parsed.Host != "" {
+		candidate = strings.TrimSuffix(parsed.Path, "/")
+		if index := strings.LastIndex(candidate, "/"); index >= 0 {
+			candidate = candidate[index+1:]
+		}
+	}
+
+	match := pageIDRe.FindStringSubmatch(candidate)
+	if match == nil {
+		return "", fmt.Errorf("not a notion page link or id: %q", value)
+	}
+	return strings.ToLower(strings.Join(match[1:], "-")), nil
+}
+
+// PageURL returns the browser link of a page.
+func PageURL(pageID string) string {
+	return "https://www.notion.so/" + strings.ReplaceAll(pageID, "-", "")
+}
+
+func splitChildren(children []notionapi.Block) ([]notionapi.Block, []notionapi.Block) {
+	if len(children) <= childrenLimit {
+		return children, nil
+	}
+	return children[:childrenLimit], children[childrenLimit:]
+}
+
+// withRetry runs call up to three times, backing off between attempts.
+func withRetry(ctx context.Context, call func() error) error {
+	var lastErr error
+	for attempt := 0; attempt < 3; attempt++ {
+		lastErr = call()
+		if lastErr == nil {
+			return nil
 		}
-		lastErr = err
 
 		select {
 		case <-ctx.Done():
-			return "", ctx.Err()
+			return ctx.Err()
 		case <-time.After(time.Duration(attempt+1) * 500 * time.Millisecond):
 		}
 	}
-
-	return "", fmt.Errorf("notion create page failed: %w", lastErr)
+	return lastErr
 }
@@ -1,6 +1,11 @@
 package notion
 
 import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/jomei/notionapi"
@@ -120,3 +125,127 @@ func TestCreatePage_MultipleBlockTypes(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePageID(t *testing.T) {
+	const want = "0123abcd-4567-89ef-0123-456789abcdef"
+	tests := []struct {
+		name    string
+		input   string
+		wantErr bool
+	}{
+		{name: "bare id", input: "0123abcd456789ef0123456789abcdef"},
+		{name: "dashed id", input: " 0123ABCD-4567-89ef-0123-456789abcdef "},
+		{name: "page link", input: "https://www.notion.so/workspace/Research-Notes-0123abcd456789ef0123456789abcdef"},
+		{name: "page link with query", input: "https://notion.so/Research-0123abcd456789ef0123456789abcdef?pvs=4"},
+		{name: "too short", input: "0123abcd", wantErr: true},
+		{name: "empty", input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePageID(tt.input)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != want {
+				t.Fatalf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestAppendBlocks_Batches(t *testing.T) {
+	var batches []int
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		var body struct {
+			Children []json.RawMessage `json:"children"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			t.Fatalf("decode request: %v", err)
+		}
+		if req.Method != http.MethodPatch || !strings.HasSuffix(req.URL.Path, "/blocks/page-id/children") {
+			t.Fatalf("unexpected request %s %s", req.Method, req.URL.Path)
+		}
+		batches = append(batches, len(body.Children))
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"object":"list","results":[]}`)),
+			Request:    req,
+		}, nil
+	})
+	client := &Client{client: notionapi.NewClient("test-token", notionapi.WithHTTPClient(&http.Client{Transport: transport}))}
+
+	blocks := make([]notionapi.Block, 0, 250)
+	for i := 0; i < 250; i++ {
+		blocks = append(blocks, &notionapi.ParagraphBlock{
+			BasicBlock: notionapi.BasicBlock{Object: "block", Type: "paragraph"},
+			Paragraph:  notionapi.Paragraph{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "line"}}}},
+		})
+	}
+
+	if err := client.AppendBlocks(context.Background(), "page-id", blocks); err != nil {
+		t.Fatalf("AppendBlocks returned error: %v", err)
+	}
+	if len(batches) != 3 || batches[0] != 100 || batches[1] != 100 || batches[2] != 50 {
+		t.Fatalf("unexpected batches %v", batches)
+	}
+}
+
+func TestPageURL(t *testing.T) {
+	got := PageURL("0123abcd-4567-89ef-0123-456789abcdef")
+	if got != "https://www.notion.so/0123abcd456789ef0123456789abcdef" {
+		t.Fatalf("unexpected page url %q", got)
+	}
+}
+
+func TestPageProperties(t *testing.T) {
+	page := Page{TitleProperty: "Name", Title: "Notes", OriginProperty: "Origin", Origin: "Telegram"}
+	properties := page.properties()
+	if len(properties) != 2 {
+		t.Fatalf("expected title and origin properties, got %#v", properties)
+	}
+
+	page.SourceProperty = "Source"
+	page.Source = []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "News"}}}
+	source, ok := page.properties()["Source"].(notionapi.RichTextProperty)
+	if !ok || len(source.RichText) != 1 || source.RichText[0].Text.Content != "News" {
+		t.Fatalf("unexpected source property: %#v", page.properties()["Source"])
+	}
+}
+
+func TestCreatePage_ReturnsURL(t *testing.T) {
+	transport := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/pages") {
+			t.Fatalf("unexpected request %s %s", req.Method, req.URL.Path)
+		}
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"object":"page","id":"0123abcd-4567-89ef-0123-456789abcdef","url":"https://www.notion.so/Notes-0123abcd456789ef0123456789abcdef"}`)),
+			Request:    req,
+		}, nil
+	})
+	client := &Client{client: notionapi.NewClient("test-token", notionapi.WithHTTPClient(&http.Client{Transport: transport}))}
+
+	page := Page{TitleProperty: "Name", Title: "Notes"}
+	created, err := client.CreatePage(context.Background(), "database-id", page, nil)
+	if err != nil {
+		t.Fatalf("CreatePage returned error: %v", err)
+	}
+	if created.ID != "0123abcd-4567-89ef-0123-456789abcdef" || created.URL != "https://www.notion.so/Notes-0123abcd456789ef0123456789abcdef" {
+		t.Fatalf("unexpected created page %#v", created)
+	}
+}
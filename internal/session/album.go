@@ -0,0 +1,182 @@
+package session
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jomei/notionapi"
+)
+
+// albumColumns is the widest gallery rendered for an album; larger albums
+// wrap into further rows.
+const albumColumns = 3
+
+// albumSettleDelay is how long an album waits for more of its messages before
+// it is saved on its own in journal and instant mode.
+const albumSettleDelay = 2 * time.Second
+
+// albumFlushTimeout bounds saving one released album. Albums are saved after
+// the update that completed them was handled, so they get their own deadline.
+const albumFlushTimeout = time.Minute
+
+// albumOf returns the media group a block belongs to, or "".
+func albumOf(block Block) string {
+	switch b := block.(type) {
+	case ImageBlock:
+		return b.Album
+	case FileBlock:
+		return b.Album
+	default:
+		return ""
+	}
+}
+
+// albumMembers returns the blocks of album in their captured order.
+func albumMembers(blocks []Block, album string) []Block {
+	members := make([]Block, 0, len(blocks))
+	for _, block := range blocks {
+		if albumOf(block) == album {
+			members = append(members, block)
+		}
+	}
+	return members
+}
+
+// albumBlocks renders the media of one album as a single gallery.
+func (p *Pipeline) albumBlocks(ctx context.Context, chatID int64, members []Block) ([]notionapi.Block, error) {
+	media := make([]notionapi.Block, 0, len(members))
+	for _, member := range members {
+		var (
+			block notionapi.Block
+			err   error
+		)
+		switch b := member.(type) {
+		case ImageBlock:
+			block, err = p.imageBlock(ctx, chatID, b)
+		case FileBlock:
+			block, err = p.fileBlock(ctx, chatID, b)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if block != nil {
+			media = append(media, block)
+		}
+	}
+
+	if len(media) < 2 {
+		return media, nil
+	}
+	return []notionapi.Block{galleryBlock(media)}, nil
+}
+
+// galleryBlock lays media out in a column list, filling the columns row by
+// row so the album keeps its order when read left to right.
+func galleryBlock(media []notionapi.Block) notionapi.Block {
+	columns := make([]notionapi.Block, min(len(media), albumColumns))
+	children := make([]notionapi.Blocks, len(columns))
+	for i, block := range media {
+		children[i%len(columns)] = append(children[i%len(columns)], block)
+	}
+	for i := range columns {
+		columns[i] = &notionapi.ColumnBlock{
+			BasicBlock: notionapi.BasicBlock{Object: "block", Type: "column"},
+			Column:     notionapi.Column{Children: children[i]},
+		}
+	}
+
+	return &notionapi.ColumnListBlock{
+		BasicBlock: notionapi.BasicBlock{Object: "block", Type: "column_list"},
+		ColumnList: notionapi.ColumnList{Children: columns},
+	}
+}
+
+// albumKey identifies a media group within a chat.
+type albumKey struct {
+	chatID int64
+	album  string
+}
+
+type pendingAlbum struct {
+	blocks []Block
+	timer  *time.Timer
+}
+
+// AlbumCollector holds back the messages of a media group until no more
+// arrive for the settle delay, then hands them over together. Platforms
+// deliver each album item as its own message, which journal and instant mode
+// would otherwise save one by one.
+type AlbumCollector struct {
+	mu       sync.Mutex
+	delay    time.Duration
+	pending  map[albumKey]*pendingAlbum
+	flush    func(ctx context.Context, chatID int64, blocks []Block)
+	inflight sync.WaitGroup
+}
+
+func NewAlbumCollector(delay time.Duration, flush func(ctx context.Context, chatID int64, blocks []Block)) *AlbumCollector {
+	return &AlbumCollector{
+		delay:   delay,
+		pending: make(map[albumKey]*pendingAlbum),
+		flush:   flush,
+	}
+}
+
+// Add buffers the blocks of one album message and restarts the settle timer.
+func (c *AlbumCollector) Add(chatID int64, album string, blocks []Block) {
+	key := albumKey{chatID: chatID, album: album}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.pending[key]
+	if !ok {
+		entry = &pendingAlbum{}
+		c.pending[key] = entry
+		entry.timer = time.AfterFunc(c.delay, func() { c.release(key) })
+	} else {
+		entry.timer.Reset(c.delay)
+	}
+	entry.blocks = append(entry.blocks, blocks...)
+}
+
+// Flush hands over every pending album at once using ctx, and waits for
+// albums already being handed over. It is called when updates stop arriving,
+// so no album is lost to a settle timer that never fires.
+func (c *AlbumCollector) Flush(ctx context.Context) {
+	c.mu.Lock()
+	pending := c.pending
+	c.pending = make(map[albumKey]*pendingAlbum)
+	for _, entry := range pending {
+		entry.timer.Stop()
+	}
+	c.mu.Unlock()
+
+	for key, entry := range pending {
+		if len(entry.blocks) > 0 {
+			c.flush(ctx, key.chatID, entry.blocks)
+		}
+	}
+	c.inflight.Wait()
+}
+
+func (c *AlbumCollector) release(key albumKey) {
+	c.mu.Lock()
+	entry, ok := c.pending[key]
+	delete(c.pending, key)
+	if ok {
+		c.inflight.Add(1)
+	}
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	defer c.inflight.Done()
+
+	if len(entry.blocks) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), albumFlushTimeout)
+		defer cancel()
+		c.flush(ctx, key.chatID, entry.blocks)
+	}
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jomei/notionapi"
+)
+
+func TestGalleryBlock(t *testing.T) {
+	media := make([]notionapi.Block, 5)
+	for i := range media {
+		media[i] = placeholderBlock(string(rune('a' + i)))
+	}
+
+	list, ok := galleryBlock(media).(*notionapi.ColumnListBlock)
+	if !ok {
+		t.Fatalf("expected column list, got %#v", galleryBlock(media))
+	}
+	if len(list.ColumnList.Children) != albumColumns {
+		t.Fatalf("expected %d columns, got %d", albumColumns, len(list.ColumnList.Children))
+	}
+	first := list.ColumnList.Children[0].(*notionapi.ColumnBlock)
+	if len(first.Column.Children) != 2 || first.Column.Children[1] != media[3] {
+		t.Fatalf("expected first column to hold items 0 and 3, got %#v", first.Column.Children)
+	}
+}
+
+func TestPipelineBuildBlocksGroupsAlbums(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not an image"))
+	}))
+	defer server.Close()
+
+	pipeline := NewPipeline(nil, &fakePlatform{}, nil, nil, nil, nil, nil)
+	session := &Session{ChatID: 1, Blocks: []Block{
+		ImageBlock{FileURL: server.URL, Album: "a"},
+		TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "between"}}}},
+		ImageBlock{FileURL: server.URL, Album: "a"},
+		ImageBlock{FileURL: server.URL, Album: "b"},
+	}}
+
+	blocks, err := pipeline.BuildBlocks(context.Background(), session)
+	if err != nil {
+		t.Fatalf("BuildBlocks() error = %v", err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("expected gallery, text and single image, got %d blocks", len(blocks))
+	}
+	if blocks[0].GetType() != "column_list" || blocks[1].GetType() != "paragraph" || blocks[2].GetType() != "paragraph" {
+		t.Fatalf("unexpected block types: %q %q %q", blocks[0].GetType(), blocks[1].GetType(), blocks[2].GetType())
+	}
+}
+
+func TestAlbumCollectorFlushesTogether(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		flushed [][]Block
+		done    = make(chan struct{}, 2)
+	)
+	collector := NewAlbumCollector(20*time.Millisecond, func(ctx context.Context, chatID int64, blocks []Block) {
+		mu.Lock()
+		flushed = append(flushed, blocks)
+		mu.Unlock()
+		done <- struct{}{}
+	})
+
+	collector.Add(1, "a", []Block{ImageBlock{MessageID: "1", Album: "a"}})
+	collector.Add(1, "a", []Block{ImageBlock{MessageID: "2", Album: "a"}})
+	collector.Add(2, "a", []Block{ImageBlock{MessageID: "3", Album: "a"}})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("album was not flushed")
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(flushed) != 2 || len(flushed[0])+len(flushed[1]) != 3 {
+		t.Fatalf("unexpected flushes: %#v", flushed)
+	}
+}
+
+func TestAlbumCollectorFlushesPendingOnStop(t *testing.T) {
+	var flushed [][]Block
+	collector := NewAlbumCollector(time.Hour, func(ctx context.Context, chatID int64, blocks []Block) {
+		if ctx.Err() != nil {
+			t.Errorf("expected a live context, got %v", ctx.Err())
+		}
+		flushed = append(flushed, blocks)
+	})
+
+	collector.Add(1, "a", []Block{ImageBlock{MessageID: "1", Album: "a"}})
+	collector.Add(1, "a", []Block{ImageBlock{MessageID: "2", Album: "a"}})
+	collector.Flush(context.Background())
+
+	if len(flushed) != 1 || len(flushed[0]) != 2 {
+		t.Fatalf("expected the pending album to be flushed, got %#v", flushed)
+	}
+}
@@ -1,35 +1,248 @@
 package session
 
-import "github.com/jomei/notionapi"
+import (
+	"time"
+
+	"github.com/jomei/notionapi"
+)
 
 type Block interface {
 	Kind() string
+	// Source is the ID of the chat message the block was captured from, so
+	// edits and deletions can find it again. It is empty when unknown.
+	Source() string
 }
 
 type TextBlock struct {
-	RichText []notionapi.RichText
+	RichText  []notionapi.RichText `json:"rich_text"`
+	MessageID string               `json:"message_id,omitempty"`
+}
+
+type QuoteBlock struct {
+	RichText  []notionapi.RichText `json:"rich_text"`
+	MessageID string               `json:"message_id,omitempty"`
 }
 
 type CodeBlock struct {
-	Content  string
-	Language string
+	Content   string `json:"content"`
+	Language  string `json:"language,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
 }
 
 type ImageBlock struct {
-	FileID   string
-	FileURL  string
-	Filename string
-	Caption  string
+	FileID   string `json:"file_id,omitempty"`
+	FileURL  string `json:"file_url,omitempty"`
+	Filename string `json:"filename,omitempty"`
+	Caption  string `json:"caption,omitempty"`
+	// CaptionRichText is the formatted caption, when the platform has one.
+	CaptionRichText []notionapi.RichText `json:"caption_rich_text,omitempty"`
+	MessageID       string               `json:"message_id,omitempty"`
+	// Album is the media group the image was sent in, if any; the blocks of
+	// an album are rendered together.
+	Album string `json:"album,omitempty"`
+}
+
+// SourceBlock attributes the blocks that follow it to the chat or person a
+// message was forwarded from, or to the channel a mirrored post appeared in.
+type SourceBlock struct {
+	Name string `json:"name"`
+	// URL links to the original post or the author's profile, when public.
+	URL       string    `json:"url,omitempty"`
+	Date      time.Time `json:"date,omitempty"`
+	MessageID string    `json:"message_id,omitempty"`
+	// Album is set for forwarded albums, which are attributed once.
+	Album string `json:"album,omitempty"`
+	// Posted marks a mirrored channel post linking back to itself rather
+	// than a forward.
+	Posted bool `json:"posted,omitempty"`
+}
+
+// StickerBlock is a sticker saved as an image. Animated stickers have no
+// still image of their own, so their thumbnail stands in; Emoji is the
+// fallback when neither can be shown.
+type StickerBlock struct {
+	FileID      string `json:"file_id,omitempty"`
+	ThumbnailID string `json:"thumbnail_id,omitempty"`
+	Animated    bool   `json:"animated,omitempty"`
+	Emoji       string `json:"emoji,omitempty"`
+	SetName     string `json:"set_name,omitempty"`
+	MessageID   string `json:"message_id,omitempty"`
+}
+
+// LocationBlock is a shared location, or a venue when Title is set.
+type LocationBlock struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Title     string  `json:"title,omitempty"`
+	Address   string  `json:"address,omitempty"`
+	MessageID string  `json:"message_id,omitempty"`
+}
+
+// ContactBlock is a shared contact card.
+type ContactBlock struct {
+	FirstName   string `json:"first_name"`
+	LastName    string `json:"last_name,omitempty"`
+	PhoneNumber string `json:"phone_number"`
+	MessageID   string `json:"message_id,omitempty"`
+}
+
+// PollBlock is a poll with the vote counts seen when it was captured.
+type PollBlock struct {
+	Question   string       `json:"question"`
+	Options    []PollOption `json:"options"`
+	TotalVotes int          `json:"total_votes"`
+	Closed     bool         `json:"closed,omitempty"`
+	MessageID  string       `json:"message_id,omitempty"`
+}
+
+type PollOption struct {
+	Text  string `json:"text"`
+	Votes int    `json:"votes"`
+}
+
+// Media kinds of a FileBlock.
+const (
+	MediaDocument  = "document"
+	MediaAudio     = "audio"
+	MediaVoice     = "voice"
+	MediaVideo     = "video"
+	MediaAnimation = "animation"
+)
+
+// FileBlock is a document, audio file, voice note, video or animation.
+type FileBlock struct {
+	FileID          string               `json:"file_id,omitempty"`
+	FileURL         string               `json:"file_url,omitempty"`
+	Filename        string               `json:"filename,omitempty"`
+	MimeType        string               `json:"mime_type,omitempty"`
+	Size            int64                `json:"size,omitempty"`
+	Media           string               `json:"media"`
+	Caption         string               `json:"caption,omitempty"`
+	CaptionRichText []notionapi.RichText `json:"caption_rich_text,omitempty"`
+	MessageID       string               `json:"message_id,omitempty"`
+	Album           string               `json:"album,omitempty"`
 }
 
 func (t TextBlock) Kind() string {
 	return "text"
 }
 
+func (t TextBlock) Source() string {
+	return t.MessageID
+}
+
+func (q QuoteBlock) Kind() string {
+	return "quote"
+}
+
+func (q QuoteBlock) Source() string {
+	return q.MessageID
+}
+
 func (c CodeBlock) Kind() string {
 	return "code"
 }
 
+func (c CodeBlock) Source() string {
+	return c.MessageID
+}
+
 func (i ImageBlock) Kind() string {
 	return "image"
 }
+
+func (i ImageBlock) Source() string {
+	return i.MessageID
+}
+
+func (f FileBlock) Kind() string {
+	return "file"
+}
+
+func (f FileBlock) Source() string {
+	return f.MessageID
+}
+
+func (s StickerBlock) Kind() string {
+	return "sticker"
+}
+
+func (s StickerBlock) Source() string {
+	return s.MessageID
+}
+
+func (l LocationBlock) Kind() string {
+	return "location"
+}
+
+func (l LocationBlock) Source() string {
+	return l.MessageID
+}
+
+func (c ContactBlock) Kind() string {
+	return "contact"
+}
+
+func (c ContactBlock) Source() string {
+	return c.MessageID
+}
+
+func (p PollBlock) Kind() string {
+	return "poll"
+}
+
+func (p PollBlock) Source() string {
+	return p.MessageID
+}
+
+func (s SourceBlock) Kind() string {
+	return "source"
+}
+
+func (s SourceBlock) Source() string {
+	return s.MessageID
+}
+
+// replyQuote renders the message a reply answers as a quote, led by its
+// author's name when known.
+func replyQuote(author string, richText []notionapi.RichText, messageID string) (QuoteBlock, bool) {
+	if len(richText) == 0 {
+		return QuoteBlock{}, false
+	}
+
+	quote := make([]notionapi.RichText, 0, len(richText)+1)
+	if author != "" {
+		quote = append(quote, notionapi.RichText{
+			Type:        "text",
+			Text:        &notionapi.Text{Content: author + ": "},
+			Annotations: &notionapi.Annotations{Bold: true},
+		})
+	}
+	quote = append(quote, richText...)
+	return QuoteBlock{RichText: quote, MessageID: messageID}, true
+}
+
+// replaceSource swaps every block captured from messageID for replacement,
+// inserted where the first of them was. It reports whether any block matched.
+func replaceSource(blocks []Block, messageID string, replacement []Block) ([]Block, bool) {
+	if messageID == "" {
+		return blocks, false
+	}
+
+	result := make([]Block, 0, len(blocks)+len(replacement))
+	found := false
+	for _, block := range blocks {
+		if block.Source() != messageID {
+			result = append(result, block)
+			continue
+		}
+		if !found {
+			result = append(result, replacement...)
+			found = true
+		}
+	}
+	if !found {
+		return blocks, false
+	}
+	return result, true
+}
@@ -0,0 +1,57 @@
+package session
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// handleChannelPost mirrors a post from a channel listed in
+// telegram.channel_ids. Telegram only delivers posts of channels the bot
+// administers.
+func (r *Runner) handleChannelPost(ctx context.Context, post *tgbotapi.Message) {
+	if post.Chat == nil || !r.cfg.Telegram.ChannelAllowed(post.Chat.ID) {
+		return
+	}
+	chatID := r.conversations.remember(conversation{ChatID: post.Chat.ID, Channel: true, Title: channelTitle(post.Chat)})
+
+	blocks := r.channelBlocks(post)
+	if post.MediaGroupID != "" {
+		r.albums.Add(chatID, post.MediaGroupID, blocks)
+		return
+	}
+	r.capture(ctx, chatID, blocks)
+}
+
+// handleChannelEdit notes edits to mirrored posts. The post is already in
+// Notion by then, so the edit is not applied.
+func (r *Runner) handleChannelEdit(post *tgbotapi.Message) {
+	if post.Chat == nil || !r.cfg.Telegram.ChannelAllowed(post.Chat.ID) {
+		return
+	}
+	if r.logger != nil {
+		r.logger.Info("ignoring edited channel post", zap.Int64("chat_id", post.Chat.ID), zap.Int("message_id", post.MessageID))
+	}
+}
+
+// channelBlocks converts a channel post, led by a link back to it.
+func (r *Runner) channelBlocks(post *tgbotapi.Message) []Block {
+	source := SourceBlock{
+		Name:      channelTitle(post.Chat),
+		URL:       telegramPostURL(post.Chat, post.MessageID),
+		Date:      time.Unix(int64(post.Date), 0),
+		MessageID: telegramMessageID(post),
+		Album:     post.MediaGroupID,
+		Posted:    true,
+	}
+	return append([]Block{source}, r.messageBlocks(post)...)
+}
+
+func channelTitle(chat *tgbotapi.Chat) string {
+	if chat.Title != "" {
+		return chat.Title
+	}
+	return chat.UserName
+}
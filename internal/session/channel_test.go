@@ -0,0 +1,70 @@
+package session
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/jomei/notionapi"
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"github.com/nerdneilsfield/telenotion-bot/internal/tgclient"
+)
+
+func TestRunnerChannelBlocks(t *testing.T) {
+	r := &Runner{mapper: tgclient.NewMapper()}
+	post := &tgbotapi.Message{
+		MessageID: 42,
+		Date:      1714552200,
+		Chat:      &tgbotapi.Chat{ID: -1001234, Type: "channel", Title: "News", UserName: "news"},
+		Text:      "hello",
+	}
+
+	blocks := r.channelBlocks(post)
+	if len(blocks) != 2 {
+		t.Fatalf("expected link and text blocks, got %#v", blocks)
+	}
+	source, ok := blocks[0].(SourceBlock)
+	if !ok || !source.Posted || source.Name != "News" || source.URL != "https://t.me/news/42" || source.Source() != "42" {
+		t.Fatalf("unexpected source block: %#v", blocks[0])
+	}
+}
+
+func TestPipelineBuildBlocksPosted(t *testing.T) {
+	pipeline := NewPipeline(nil, &fakePlatform{}, nil, nil, nil, nil, nil)
+	session := &Session{ChatID: 1, Blocks: []Block{
+		SourceBlock{Name: "News", URL: "https://t.me/news/1", Album: "a", Posted: true},
+		SourceBlock{Name: "Wire", URL: "https://t.me/wire/9", Album: "a"},
+		SourceBlock{Name: "News", URL: "https://t.me/news/2", Album: "a", Posted: true},
+	}}
+
+	blocks, err := pipeline.BuildBlocks(context.Background(), session)
+	if err != nil {
+		t.Fatalf("BuildBlocks() error = %v", err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("expected the post link and the forward, got %d blocks", len(blocks))
+	}
+	callout := blocks[0].(*notionapi.CalloutBlock)
+	if callout.Callout.RichText[0].Text.Content != "Posted in " || *callout.Callout.Icon.Emoji != postedEmoji {
+		t.Fatalf("unexpected post link: %#v", callout.Callout)
+	}
+}
+
+func TestRunnerNeverRepliesInChannel(t *testing.T) {
+	conversations, err := openConversations(&config.Config{}, "telegram", nil)
+	if err != nil {
+		t.Fatalf("openConversations() error = %v", err)
+	}
+	r := &Runner{conversations: conversations}
+
+	chatID := conversations.remember(conversation{ChatID: -1001234, Channel: true, Title: "News"})
+	if chatID != -1001234 {
+		t.Fatalf("expected channels to keep their ID, got %d", chatID)
+	}
+	if conv := conversations.lookup(chatID); !conv.Channel || conv.Title != "News" {
+		t.Fatalf("unexpected channel conversation: %#v", conv)
+	}
+
+	// The runner has no Telegram client, so sending would panic.
+	r.reply(chatID, "Saved to Notion.")
+}
@@ -0,0 +1,352 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/nerdneilsfield/telenotion-bot/internal/notion"
+	"go.uber.org/zap"
+)
+
+const helpText = "Commands:\n/start [name] - start a new capture session\n/switch <name> - switch the active session\n/sessions - list sessions\n/preview - show what is buffered\n/undo [n] - remove the last n blocks\n/clean - clear the current buffer\n/discard [name] - abandon a session\n/end [name] - create a Notion page and end session\n/append [page] - add the session to an existing page, or the last saved one\n/last - show the most recently saved page\n/help - show this help"
+
+var sessionNameRe = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// Commands implements the chat commands shared by every platform. Runners
+// parse the command name and arguments and deliver the returned response.
+type Commands struct {
+	stateMachine *StateMachine
+	pipeline     *Pipeline
+	logger       *zap.Logger
+}
+
+func NewCommands(stateMachine *StateMachine, pipeline *Pipeline, logger *zap.Logger) *Commands {
+	return &Commands{stateMachine: stateMachine, pipeline: pipeline, logger: logger}
+}
+
+// Execute runs command for chatID and returns the response. The boolean is
+// false for unknown commands.
+func (c *Commands) Execute(ctx context.Context, chatID int64, command string, args string) (string, bool) {
+	args = strings.TrimSpace(args)
+
+	switch command {
+	case "help":
+		return helpText, true
+	case "start":
+		return c.start(chatID, args), true
+	case "switch":
+		return c.switchTo(chatID, args), true
+	case "sessions":
+		return c.list(chatID), true
+	case "preview":
+		return c.preview(chatID), true
+	case "undo":
+		return c.undo(chatID, args), true
+	case "clean":
+		return c.clean(chatID), true
+	case "discard":
+		return c.discard(chatID, args), true
+	case "end":
+		return c.end(ctx, chatID, args), true
+	case "append":
+		return c.appendTo(ctx, chatID, args), true
+	case "last":
+		return c.last(chatID), true
+	default:
+		return "", false
+	}
+}
+
+func (c *Commands) start(chatID int64, args string) string {
+	if args == "" {
+		if !c.stateMachine.StartSession(chatID) {
+			return "Session already active. Use /clean to clear or /end to save."
+		}
+		c.log("session started", chatID, DefaultSessionName)
+		return "Session started. Send messages or images, then /end to save."
+	}
+
+	name, ok := normalizeSessionName(args)
+	if !ok {
+		return invalidSessionNameMessage
+	}
+	if !c.stateMachine.StartNamedSession(chatID, name) {
+		return fmt.Sprintf("Session %q already exists. Use /switch %s to continue it.", name, name)
+	}
+	c.log("session started", chatID, name)
+	return fmt.Sprintf("Session %q started. Send messages or images, then /end to save.", name)
+}
+
+func (c *Commands) switchTo(chatID int64, args string) string {
+	if args == "" {
+		return "Usage: /switch <name>"
+	}
+	name, ok := normalizeSessionName(args)
+	if !ok {
+		return invalidSessionNameMessage
+	}
+	if !c.stateMachine.SwitchSession(chatID, name) {
+		return fmt.Sprintf("No session named %q. Use /sessions to list them.", name)
+	}
+
+	session := c.stateMachine.GetSession(chatID)
+	return fmt.Sprintf("Switched to session %q (%s).", name, pluralBlocks(len(session.Blocks)))
+}
+
+func (c *Commands) list(chatID int64) string {
+	sessions := c.stateMachine.Sessions(chatID)
+	if len(sessions) == 0 {
+		return "No sessions. Use /start to begin."
+	}
+
+	active := c.stateMachine.GetSession(chatID)
+	var builder strings.Builder
+	builder.WriteString("Sessions:")
+	for _, session := range sessions {
+		marker := "  "
+		if active != nil && active.Name == session.Name {
+			marker = "* "
+		}
+		fmt.Fprintf(&builder, "\n%s%s - %s", marker, session.Name, pluralBlocks(len(session.Blocks)))
+	}
+	return builder.String()
+}
+
+func (c *Commands) preview(chatID int64) string {
+	session := c.stateMachine.GetSession(chatID)
+	if session == nil {
+		return "No active session. Use /start first."
+	}
+	return previewSession(session)
+}
+
+func (c *Commands) undo(chatID int64, args string) string {
+	n := 1
+	if args != "" {
+		parsed, err := strconv.Atoi(args)
+		if err != nil || parsed <= 0 {
+			return "Usage: /undo [n] where n is a positive number."
+		}
+		n = parsed
+	}
+
+	removed, ok := c.stateMachine.UndoBlocks(chatID, n)
+	if !ok {
+		return "No active session. Use /start first."
+	}
+	if removed == 0 {
+		return "Nothing to undo."
+	}
+
+	session := c.stateMachine.GetSession(chatID)
+	return fmt.Sprintf("Removed %s. %s left.", pluralBlocks(removed), pluralBlocks(len(session.Blocks)))
+}
+
+func (c *Commands) clean(chatID int64) string {
+	if !c.stateMachine.ClearSession(chatID) {
+		return "No active session. Use /start first."
+	}
+	c.log("session cleared", chatID, "")
+	return "Buffer cleared. Continue sending messages."
+}
+
+func (c *Commands) discard(chatID int64, args string) string {
+	if args == "" {
+		session := c.stateMachine.GetSession(chatID)
+		if session == nil || !c.stateMachine.DiscardSession(chatID) {
+			return "No active session to discard."
+		}
+		c.log("session discarded", chatID, session.Name)
+		return "Session discarded." + c.activeSuffix(chatID)
+	}
+
+	name, ok := normalizeSessionName(args)
+	if !ok {
+		return invalidSessionNameMessage
+	}
+	if _, ok := c.stateMachine.EndNamedSession(chatID, name); !ok {
+		return fmt.Sprintf("No session named %q.", name)
+	}
+	c.log("session discarded", chatID, name)
+	return fmt.Sprintf("Session %q discarded.", name) + c.activeSuffix(chatID)
+}
+
+func (c *Commands) end(ctx context.Context, chatID int64, args string) string {
+	var (
+		session *Session
+		ok      bool
+	)
+	if args == "" {
+		session, ok = c.stateMachine.EndSession(chatID)
+		if !ok {
+			return "No active session. Use /start first."
+		}
+	} else {
+		name, valid := normalizeSessionName(args)
+		if !valid {
+			return invalidSessionNameMessage
+		}
+		session, ok = c.stateMachine.EndNamedSession(chatID, name)
+		if !ok {
+			return fmt.Sprintf("No session named %q.", name)
+		}
+	}
+
+	return c.pipeline.Finish(ctx, session) + c.activeSuffix(chatID)
+}
+
+func (c *Commands) appendTo(ctx context.Context, chatID int64, args string) string {
+	var pageID string
+	if args == "" {
+		lastPage, ok := c.pipeline.LastPage(chatID)
+		if !ok {
+			return "Usage: /append <page link or id>. Without a page, the last saved page is used once there is one."
+		}
+		pageID = lastPage.ID
+	} else {
+		parsed, err := notion.ParsePageID(args)
+		if err != nil {
+			return "That is not a Notion page link or id."
+		}
+		pageID = parsed
+	}
+
+	session, ok := c.stateMachine.EndSession(chatID)
+	if !ok {
+		return "No active session. Use /start first."
+	}
+	session.AppendTo = pageID
+
+	return c.pipeline.Finish(ctx, session) + c.activeSuffix(chatID)
+}
+
+func (c *Commands) last(chatID int64) string {
+	page, ok := c.pipeline.LastPage(chatID)
+	if !ok {
+		return "Nothing saved yet. Use /end to save a session."
+	}
+	if page.Title != "" {
+		return fmt.Sprintf("Last saved to %q: %s", page.Title, page.URL)
+	}
+	return "Last saved to " + page.URL
+}
+
+// activeSuffix tells the user which session now receives messages after the
+// previous one went away.
+func (c *Commands) activeSuffix(chatID int64) string {
+	session := c.stateMachine.GetSession(chatID)
+	if session == nil {
+		return ""
+	}
+	return fmt.Sprintf(" Now capturing into %q.", session.Name)
+}
+
+func (c *Commands) log(message string, chatID int64, name string) {
+	if c.logger == nil {
+		return
+	}
+	fields := []zap.Field{c.pipeline.platform.ChatField(chatID)}
+	if name != "" {
+		fields = append(fields, zap.String("session", name))
+	}
+	c.logger.Info(message, fields...)
+}
+
+const invalidSessionNameMessage = "Session names use 1-32 letters, digits, '-' or '_'."
+
+func normalizeSessionName(value string) (string, bool) {
+	name := strings.ToLower(strings.TrimSpace(value))
+	return name, sessionNameRe.MatchString(name)
+}
+
+const previewLimit = 20
+
+// previewSession renders a compact, numbered summary of the most recent
+// blocks in session.
+func previewSession(session *Session) string {
+	if len(session.Blocks) == 0 {
+		return fmt.Sprintf("Session %q is empty.", session.Name)
+	}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Session %q (%s):", session.Name, pluralBlocks(len(session.Blocks)))
+
+	start := 0
+	if len(session.Blocks) > previewLimit {
+		start = len(session.Blocks) - previewLimit
+		fmt.Fprintf(&builder, "\n... %s not shown", pluralBlocks(start))
+	}
+	for i := start; i < len(session.Blocks); i++ {
+		fmt.Fprintf(&builder, "\n%d. %s", i+1, summarizeBlock(session.Blocks[i]))
+	}
+	return builder.String()
+}
+
+func summarizeBlock(block Block) string {
+	switch b := block.(type) {
+	case TextBlock:
+		return "text: " + truncateRunes(plainText(b.RichText), 60)
+	case QuoteBlock:
+		return "quote: " + truncateRunes(plainText(b.RichText), 60)
+	case CodeBlock:
+		label := "code"
+		if b.Language != "" {
+			label = "code (" + b.Language + ")"
+		}
+		firstLine, _, _ := strings.Cut(strings.TrimSpace(b.Content), "\n")
+		return label + ": " + truncateRunes(firstLine, 60)
+	case ImageBlock:
+		if b.Caption != "" {
+			return "image: " + truncateRunes(b.Caption, 60)
+		}
+		if b.Filename != "" {
+			return "image: " + b.Filename
+		}
+		return "image"
+	case FileBlock:
+		if b.Caption != "" {
+			return b.Media + ": " + truncateRunes(b.Caption, 60)
+		}
+		if b.Filename != "" {
+			return b.Media + ": " + b.Filename
+		}
+		return b.Media
+	case StickerBlock:
+		return "sticker " + b.Emoji
+	case LocationBlock:
+		if b.Title != "" {
+			return "venue: " + truncateRunes(b.Title, 60)
+		}
+		return "location: " + coordinates(b)
+	case ContactBlock:
+		return "contact: " + contactName(b)
+	case PollBlock:
+		return "poll: " + truncateRunes(b.Question, 60)
+	case SourceBlock:
+		if b.Posted {
+			return "posted in " + b.Name
+		}
+		return "forwarded from " + b.Name
+	default:
+		return block.Kind()
+	}
+}
+
+func truncateRunes(text string, limit int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= limit {
+		return text
+	}
+	return string(runes[:limit]) + "…"
+}
+
+func pluralBlocks(count int) string {
+	if count == 1 {
+		return "1 block"
+	}
+	return fmt.Sprintf("%d blocks", count)
+}
@@ -0,0 +1,121 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jomei/notionapi"
+)
+
+func TestCommandsNamedSessions(t *testing.T) {
+	sm := NewStateMachine()
+	commands := NewCommands(sm, NewPipeline(nil, &fakePlatform{}, nil, nil, nil, nil, nil), nil)
+	ctx := context.Background()
+	chatID := int64(1)
+
+	if response, _ := commands.Execute(ctx, chatID, "start", "Reading"); !strings.Contains(response, `"reading" started`) {
+		t.Fatalf("unexpected start response %q", response)
+	}
+	if response, _ := commands.Execute(ctx, chatID, "start", "bad name!"); response != invalidSessionNameMessage {
+		t.Fatalf("unexpected invalid name response %q", response)
+	}
+	commands.Execute(ctx, chatID, "start", "bug")
+	sm.AppendBlock(chatID, CodeBlock{Content: "x"})
+
+	response, _ := commands.Execute(ctx, chatID, "sessions", "")
+	if !strings.Contains(response, "* bug - 1 block") || !strings.Contains(response, "  reading - 0 blocks") {
+		t.Fatalf("unexpected sessions response %q", response)
+	}
+
+	if response, _ := commands.Execute(ctx, chatID, "switch", "missing"); !strings.HasPrefix(response, "No session named") {
+		t.Fatalf("unexpected switch response %q", response)
+	}
+	if response, _ := commands.Execute(ctx, chatID, "discard", "bug"); response != `Session "bug" discarded. Now capturing into "reading".` {
+		t.Fatalf("unexpected discard response %q", response)
+	}
+
+	if _, ok := commands.Execute(ctx, chatID, "unknown", ""); ok {
+		t.Fatalf("expected unknown command to be rejected")
+	}
+}
+
+func TestCommandsPreviewAndUndo(t *testing.T) {
+	sm := NewStateMachine()
+	commands := NewCommands(sm, NewPipeline(nil, &fakePlatform{}, nil, nil, nil, nil, nil), nil)
+	ctx := context.Background()
+	chatID := int64(1)
+
+	if response, _ := commands.Execute(ctx, chatID, "preview", ""); !strings.HasPrefix(response, "No active session") {
+		t.Fatalf("unexpected preview response %q", response)
+	}
+
+	sm.StartSession(chatID)
+	sm.AppendBlock(chatID, TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "first   note\nwith newline"}}}})
+	sm.AppendBlock(chatID, CodeBlock{Content: "package main\nfunc main() {}", Language: "go"})
+	sm.AppendBlock(chatID, ImageBlock{Caption: "a cat"})
+
+	response, _ := commands.Execute(ctx, chatID, "preview", "")
+	want := "Session \"default\" (3 blocks):\n1. text: first note with newline\n2. code (go): package main\n3. image: a cat"
+	if response != want {
+		t.Fatalf("preview = %q, want %q", response, want)
+	}
+
+	if response, _ := commands.Execute(ctx, chatID, "undo", "zero"); !strings.HasPrefix(response, "Usage") {
+		t.Fatalf("unexpected undo response %q", response)
+	}
+	if response, _ := commands.Execute(ctx, chatID, "undo", ""); response != "Removed 1 block. 2 blocks left." {
+		t.Fatalf("unexpected undo response %q", response)
+	}
+	if response, _ := commands.Execute(ctx, chatID, "undo", "5"); response != "Removed 2 blocks. 0 blocks left." {
+		t.Fatalf("unexpected undo response %q", response)
+	}
+	if response, _ := commands.Execute(ctx, chatID, "undo", ""); response != "Nothing to undo." {
+		t.Fatalf("unexpected undo response %q", response)
+	}
+}
+
+func TestCommandsAppendRequiresPage(t *testing.T) {
+	sm := NewStateMachine()
+	commands := NewCommands(sm, NewPipeline(nil, &fakePlatform{}, nil, nil, nil, nil, nil), nil)
+	ctx := context.Background()
+	chatID := int64(1)
+	sm.StartSession(chatID)
+	sm.AppendBlock(chatID, CodeBlock{Content: "x"})
+
+	if response, _ := commands.Execute(ctx, chatID, "append", ""); !strings.HasPrefix(response, "Usage: /append") {
+		t.Fatalf("unexpected append response %q", response)
+	}
+	if response, _ := commands.Execute(ctx, chatID, "append", "not-a-page"); response != "That is not a Notion page link or id." {
+		t.Fatalf("unexpected append response %q", response)
+	}
+	if session := sm.GetSession(chatID); session == nil || len(session.Blocks) != 1 {
+		t.Fatalf("expected session to stay active, got %+v", session)
+	}
+}
+
+func TestCommandsLast(t *testing.T) {
+	pipeline := NewPipeline(nil, &fakePlatform{}, nil, nil, nil, nil, nil)
+	commands := NewCommands(NewStateMachine(), pipeline, nil)
+	ctx := context.Background()
+
+	if response, _ := commands.Execute(ctx, 1, "last", ""); !strings.HasPrefix(response, "Nothing saved yet") {
+		t.Fatalf("unexpected response before any save %q", response)
+	}
+
+	pipeline.rememberPage(1, SavedPage{ID: "page", URL: "https://www.notion.so/page", Title: "Notes", Blocks: 2})
+	if response, _ := commands.Execute(ctx, 1, "last", ""); response != `Last saved to "Notes": https://www.notion.so/page` {
+		t.Fatalf("unexpected last response %q", response)
+	}
+}
+
+func TestSavedPageMessage(t *testing.T) {
+	saved := SavedPage{URL: "https://www.notion.so/page", Title: "2024-05-01 10:00", Blocks: 3}
+	if got := saved.message(); got != `Saved 3 blocks to "2024-05-01 10:00": https://www.notion.so/page` {
+		t.Fatalf("message() = %q", got)
+	}
+	appended := SavedPage{URL: "https://www.notion.so/page", Blocks: 1, Appended: true}
+	if got := appended.message(); got != "Appended 1 block to Notion: https://www.notion.so/page" {
+		t.Fatalf("message() = %q", got)
+	}
+}
@@ -0,0 +1,280 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.uber.org/zap"
+)
+
+// Callback data carried by the inline keyboard buttons. Confirmations carry
+// the command they confirm and the session it targets as
+// "confirm:<command>:<session>".
+const (
+	callbackSave    = "save"
+	callbackPreview = "preview"
+	callbackDiscard = "discard"
+	callbackConfirm = "confirm"
+	callbackCancel  = "cancel"
+)
+
+// controlRefreshDelay batches control message edits, so a burst of messages
+// costs one edit rather than one per message.
+const controlRefreshDelay = time.Second
+
+// control is the message holding a chat's Save / Preview / Discard buttons.
+// text is what it currently shows, so it is only edited when that changes.
+// pending is set while an edit is scheduled.
+type control struct {
+	messageID int
+	text      string
+	pending   bool
+}
+
+// confirmedCommands are the commands that ask before throwing blocks away.
+var confirmedCommands = map[string]bool{"clean": true, "discard": true}
+
+func controlKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData("Save", callbackSave),
+		tgbotapi.NewInlineKeyboardButtonData("Preview", callbackPreview),
+		tgbotapi.NewInlineKeyboardButtonData("Discard", callbackDiscard),
+	))
+}
+
+func confirmKeyboard(command string, name string) tgbotapi.InlineKeyboardMarkup {
+	label := "Discard"
+	if command == "clean" {
+		label = "Clear"
+	}
+	return tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(
+		tgbotapi.NewInlineKeyboardButtonData(label, callbackConfirm+":"+command+":"+name),
+		tgbotapi.NewInlineKeyboardButtonData("Cancel", callbackCancel),
+	))
+}
+
+func controlText(session *Session) string {
+	return fmt.Sprintf("Session %q: %s", session.Name, pluralBlocks(len(session.Blocks)))
+}
+
+func confirmationText(session *Session, command string) string {
+	if command == "clean" {
+		return fmt.Sprintf("Clear %s from session %q?", pluralBlocks(len(session.Blocks)), session.Name)
+	}
+	return fmt.Sprintf("Discard session %q with %s?", session.Name, pluralBlocks(len(session.Blocks)))
+}
+
+// confirm asks before /clean or /discard throws buffered blocks away. It
+// returns false when there is nothing to lose, and the command runs as is.
+// When the question cannot be sent the command does not run either.
+func (r *Runner) confirm(chatID int64, command string, args string) bool {
+	session := r.stateMachine.GetSession(chatID)
+	if args != "" {
+		name, ok := normalizeSessionName(args)
+		if !ok {
+			return false
+		}
+		session = nil
+		for _, candidate := range r.stateMachine.Sessions(chatID) {
+			if candidate.Name == name {
+				session = candidate
+			}
+		}
+	}
+	if session == nil || len(session.Blocks) == 0 {
+		return false
+	}
+
+	if _, ok := r.sendKeyboard(chatID, confirmationText(session, command), confirmKeyboard(command, session.Name)); !ok {
+		r.reply(chatID, "Could not ask for confirmation, so nothing was changed. Please try again.")
+	}
+	return true
+}
+
+// showControl posts a fresh control message for the chat's active session,
+// removing the previous one.
+func (r *Runner) showControl(chatID int64) {
+	session := r.stateMachine.GetSession(chatID)
+	if session == nil {
+		return
+	}
+
+	r.controlsMu.Lock()
+	previous, hadPrevious := r.controls[chatID]
+	delete(r.controls, chatID)
+	r.controlsMu.Unlock()
+
+	if hadPrevious {
+		conv := r.conversations.lookup(chatID)
+		if err := r.telegram.DeleteMessage(conv.ChatID, previous.messageID); err != nil && r.logger != nil {
+			r.logger.Debug("failed to delete control message", zap.Int64("chat_id", conv.ChatID), zap.Error(err))
+		}
+	}
+
+	text := controlText(session)
+	messageID, ok := r.sendKeyboard(chatID, text, controlKeyboard())
+	if !ok {
+		return
+	}
+
+	r.controlsMu.Lock()
+	defer r.controlsMu.Unlock()
+	if r.controls == nil {
+		r.controls = make(map[int64]control)
+	}
+	r.controls[chatID] = control{messageID: messageID, text: text}
+}
+
+// refreshControl schedules bringing the chat's control message up to date
+// with its active session. Calls within controlRefreshDelay of each other
+// share one edit.
+func (r *Runner) refreshControl(chatID int64) {
+	r.controlsMu.Lock()
+	defer r.controlsMu.Unlock()
+
+	current, ok := r.controls[chatID]
+	if !ok || current.pending {
+		return
+	}
+	current.pending = true
+	r.controls[chatID] = current
+	time.AfterFunc(controlRefreshDelay, func() {
+		r.updateControl(chatID)
+	})
+}
+
+// updateControl edits the chat's control message to match its active
+// session. Once no session is left the buttons are removed.
+func (r *Runner) updateControl(chatID int64) {
+	r.controlsMu.Lock()
+	current, ok := r.controls[chatID]
+	if !ok {
+		r.controlsMu.Unlock()
+		return
+	}
+
+	session := r.stateMachine.GetSession(chatID)
+	if session == nil {
+		delete(r.controls, chatID)
+		r.controlsMu.Unlock()
+		r.editMessage(chatID, current.messageID, "No active session.", nil)
+		return
+	}
+
+	text := controlText(session)
+	r.controls[chatID] = control{messageID: current.messageID, text: text}
+	r.controlsMu.Unlock()
+	if text == current.text {
+		return
+	}
+
+	keyboard := controlKeyboard()
+	r.editMessage(chatID, current.messageID, text, &keyboard)
+}
+
+// isControl reports whether messageID is the chat's control message.
+func (r *Runner) isControl(chatID int64, messageID int) bool {
+	r.controlsMu.Lock()
+	defer r.controlsMu.Unlock()
+
+	current, ok := r.controls[chatID]
+	return ok && current.messageID == messageID
+}
+
+// handleCallback runs the action behind a pressed button. Buttons act on the
+// session of whoever pressed them, with the same checks as typed commands.
+func (r *Runner) handleCallback(ctx context.Context, query *tgbotapi.CallbackQuery, topic int) {
+	if err := r.telegram.AnswerCallback(query.ID, ""); err != nil && r.logger != nil {
+		r.logger.Debug("failed to answer callback", zap.Error(err))
+	}
+	if query.Message == nil {
+		return
+	}
+	msg := &tgbotapi.Message{Chat: query.Message.Chat, From: query.From}
+	if !r.accepts(msg) {
+		return
+	}
+	chatID := r.conversationID(msg, topic)
+	messageID := query.Message.MessageID
+
+	action, rest, _ := strings.Cut(query.Data, ":")
+	switch action {
+	case callbackSave:
+		r.runCommand(ctx, chatID, "end", "")
+	case callbackPreview:
+		r.runCommand(ctx, chatID, "preview", "")
+	case callbackDiscard:
+		session := r.stateMachine.GetSession(chatID)
+		if session == nil || !r.isControl(chatID, messageID) {
+			r.refreshControl(chatID)
+			return
+		}
+		keyboard := confirmKeyboard("discard", session.Name)
+		r.editMessage(chatID, messageID, confirmationText(session, "discard"), &keyboard)
+		r.controlsMu.Lock()
+		r.controls[chatID] = control{messageID: messageID}
+		r.controlsMu.Unlock()
+	case callbackConfirm:
+		command, name, _ := strings.Cut(rest, ":")
+		if !confirmedCommands[command] {
+			return
+		}
+		args := name
+		if command == "clean" {
+			// /clean only acts on the active session, so make sure it is
+			// still the one the question was about.
+			if session := r.stateMachine.GetSession(chatID); session == nil || session.Name != name {
+				r.editMessage(chatID, messageID, fmt.Sprintf("Session %q is no longer active, so nothing was cleared.", name), nil)
+				r.refreshControl(chatID)
+				return
+			}
+			args = ""
+		}
+		if r.isControl(chatID, messageID) {
+			r.runCommand(ctx, chatID, command, args)
+			return
+		}
+		response, _ := r.chatCommands.Execute(ctx, chatID, command, args)
+		r.editMessage(chatID, messageID, response, nil)
+		r.refreshControl(chatID)
+	case callbackCancel:
+		if r.isControl(chatID, messageID) {
+			r.refreshControl(chatID)
+			return
+		}
+		r.editMessage(chatID, messageID, "Cancelled.", nil)
+	}
+}
+
+// runCommand executes a command on behalf of a button and replies as if it
+// had been typed.
+func (r *Runner) runCommand(ctx context.Context, chatID int64, command string, args string) {
+	if response, ok := r.chatCommands.Execute(ctx, chatID, command, args); ok {
+		r.reply(chatID, response)
+	}
+	r.refreshControl(chatID)
+}
+
+// sendKeyboard sends text with buttons to the chat, and forum topic, behind
+// chatID.
+func (r *Runner) sendKeyboard(chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) (int, bool) {
+	conv := r.conversations.lookup(chatID)
+	messageID, err := r.telegram.SendKeyboard(conv.ChatID, conv.Topic, text, keyboard)
+	if err != nil {
+		if r.logger != nil {
+			r.logger.Warn("failed to send buttons", zap.Int64("chat_id", conv.ChatID), zap.Int("topic", conv.Topic), zap.Error(err))
+		}
+		return 0, false
+	}
+	return messageID, true
+}
+
+func (r *Runner) editMessage(chatID int64, messageID int, text string, keyboard *tgbotapi.InlineKeyboardMarkup) {
+	conv := r.conversations.lookup(chatID)
+	if err := r.telegram.EditMessage(conv.ChatID, messageID, text, keyboard); err != nil && r.logger != nil {
+		r.logger.Warn("failed to edit message", zap.Int64("chat_id", conv.ChatID), zap.Int("message_id", messageID), zap.Error(err))
+	}
+}
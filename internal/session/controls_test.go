@@ -0,0 +1,176 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jomei/notionapi"
+	"github.com/nerdneilsfield/telenotion-bot/internal/tgclient"
+)
+
+// fakeBotAPI records the requests a tgclient.Client makes. Messages with
+// buttons fail when failKeyboards is set.
+type fakeBotAPI struct {
+	mu            sync.Mutex
+	failKeyboards bool
+	requests      []fakeBotRequest
+}
+
+type fakeBotRequest struct {
+	method string
+	text   string
+	markup string
+}
+
+func newFakeBotAPI(t *testing.T, failKeyboards bool) (*fakeBotAPI, *tgclient.Client) {
+	t.Helper()
+	fake := &fakeBotAPI{failKeyboards: failKeyboards}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		method := path.Base(r.URL.Path)
+		if method == "getMe" {
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"id":42,"is_bot":true,"first_name":"Bot","username":"test_bot"}}`))
+			return
+		}
+
+		_ = r.ParseForm()
+		request := fakeBotRequest{method: method, text: r.Form.Get("text"), markup: r.Form.Get("reply_markup")}
+		fake.mu.Lock()
+		fake.requests = append(fake.requests, request)
+		fake.mu.Unlock()
+
+		if fake.failKeyboards && method == "sendMessage" && request.markup != "" {
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":7,"chat":{"id":1}}}`))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := tgclient.NewClient("test-token", server.URL, false)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return fake, client
+}
+
+func (f *fakeBotAPI) calls(method string) []fakeBotRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var calls []fakeBotRequest
+	for _, request := range f.requests {
+		if request.method == method {
+			calls = append(calls, request)
+		}
+	}
+	return calls
+}
+
+func TestConfirmKeyboard(t *testing.T) {
+	keyboard := confirmKeyboard("discard", "notes")
+	buttons := keyboard.InlineKeyboard[0]
+	if len(buttons) != 2 || buttons[0].CallbackData == nil || *buttons[0].CallbackData != "confirm:discard:notes" {
+		t.Fatalf("unexpected confirm button: %#v", buttons)
+	}
+	if len(*buttons[0].CallbackData) > 64 {
+		t.Fatal("callback data exceeds Telegram's 64 byte limit")
+	}
+	if *buttons[1].CallbackData != callbackCancel {
+		t.Fatalf("unexpected cancel button: %#v", buttons[1])
+	}
+}
+
+func TestControlText(t *testing.T) {
+	session := &Session{Name: "notes", Blocks: []Block{TextBlock{}, TextBlock{}}}
+	if got := controlText(session); got != `Session "notes": 2 blocks` {
+		t.Fatalf("controlText() = %q", got)
+	}
+	if got := confirmationText(session, "clean"); got != `Clear 2 blocks from session "notes"?` {
+		t.Fatalf("confirmationText(clean) = %q", got)
+	}
+	if got := confirmationText(session, "discard"); got != `Discard session "notes" with 2 blocks?` {
+		t.Fatalf("confirmationText(discard) = %q", got)
+	}
+}
+
+func TestRunnerConfirmSkipsEmptySessions(t *testing.T) {
+	sm := NewStateMachine()
+	r := &Runner{stateMachine: sm}
+
+	if r.confirm(1, "discard", "") {
+		t.Fatal("expected no confirmation without a session")
+	}
+	sm.StartSession(1)
+	if r.confirm(1, "clean", "") {
+		t.Fatal("expected no confirmation for an empty session")
+	}
+	sm.AppendBlock(1, TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "hi"}}}})
+	if r.confirm(1, "discard", "other") {
+		t.Fatal("expected no confirmation for an unknown session")
+	}
+}
+
+func TestRunnerConfirmCarriesSessionName(t *testing.T) {
+	fake, client := newFakeBotAPI(t, false)
+	sm := NewStateMachine()
+	r := &Runner{stateMachine: sm, telegram: client}
+	sm.StartSession(1)
+	sm.AppendBlock(1, TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "hi"}}}})
+
+	if !r.confirm(1, "discard", "") {
+		t.Fatal("expected a confirmation")
+	}
+	sent := fake.calls("sendMessage")
+	if len(sent) != 1 || !strings.Contains(sent[0].markup, `"confirm:discard:default"`) {
+		t.Fatalf("expected the session name in the callback data, got %#v", sent)
+	}
+}
+
+func TestRunnerConfirmFailsClosed(t *testing.T) {
+	fake, client := newFakeBotAPI(t, true)
+	sm := NewStateMachine()
+	r := &Runner{stateMachine: sm, telegram: client}
+	sm.StartSession(1)
+	sm.AppendBlock(1, TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "hi"}}}})
+
+	if !r.confirm(1, "discard", "") {
+		t.Fatal("expected the command to be held back when the question cannot be sent")
+	}
+	if sm.GetSession(1) == nil {
+		t.Fatal("expected the session to survive")
+	}
+	sent := fake.calls("sendMessage")
+	if len(sent) != 2 || sent[1].markup != "" || !strings.Contains(sent[1].text, "nothing was changed") {
+		t.Fatalf("expected an error reply, got %#v", sent)
+	}
+}
+
+func TestRefreshControlBatchesEdits(t *testing.T) {
+	fake, client := newFakeBotAPI(t, false)
+	sm := NewStateMachine()
+	r := &Runner{stateMachine: sm, telegram: client, controls: map[int64]control{1: {messageID: 7}}}
+	sm.StartSession(1)
+
+	for i := 0; i < 3; i++ {
+		sm.AppendBlock(1, TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "hi"}}}})
+		r.refreshControl(1)
+	}
+	if edits := fake.calls("editMessageText"); len(edits) != 0 {
+		t.Fatalf("expected edits to wait, got %d", len(edits))
+	}
+
+	deadline := time.Now().Add(3 * controlRefreshDelay)
+	for len(fake.calls("editMessageText")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	edits := fake.calls("editMessageText")
+	if len(edits) != 1 || edits[0].text != `Session "default": 3 blocks` {
+		t.Fatalf("expected one edit with the final count, got %#v", edits)
+	}
+}
@@ -0,0 +1,127 @@
+package session
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"go.uber.org/zap"
+)
+
+// conversation is where a Telegram message belongs: a chat, the forum topic
+// within it, and the member when group sessions are kept per user. Mirrored
+// channels are conversations too, remembered so nothing is ever sent to them.
+type conversation struct {
+	ChatID int64 `json:"chat_id"`
+	Topic  int   `json:"topic,omitempty"`
+	UserID int64 `json:"user_id,omitempty"`
+	// Channel marks a mirrored channel; Title names its pages.
+	Channel bool   `json:"channel,omitempty"`
+	Title   string `json:"title,omitempty"`
+}
+
+// id is the chat ID sessions of the conversation are kept under. A plain chat
+// keeps its Telegram ID; topics and members get a stable ID derived from the
+// conversation, far below any real chat ID.
+func (c conversation) id() int64 {
+	if c.Topic == 0 && c.UserID == 0 {
+		return c.ChatID
+	}
+
+	var buf [24]byte
+	binary.BigEndian.PutUint64(buf[0:], uint64(c.ChatID))
+	binary.BigEndian.PutUint64(buf[8:], uint64(c.Topic))
+	binary.BigEndian.PutUint64(buf[16:], uint64(c.UserID))
+	h := fnv.New64a()
+	_, _ = h.Write(buf[:])
+	return math.MinInt64 + int64(h.Sum64()>>2)
+}
+
+// conversations remembers where derived conversation IDs lead, so replies,
+// idle reminders and retried saves reach the right chat and topic. The table
+// is kept next to the session journal and survives restarts with it.
+type conversations struct {
+	mu     sync.Mutex
+	path   string
+	known  map[int64]conversation
+	logger *zap.Logger
+}
+
+func openConversations(cfg *config.Config, name string, logger *zap.Logger) (*conversations, error) {
+	c := &conversations{known: make(map[int64]conversation), logger: logger}
+	if cfg.Session.StoreDir == "" {
+		return c, nil
+	}
+
+	c.path = filepath.Join(cfg.Session.StoreDir, name+"-conversations.json")
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("open conversations: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &c.known); err != nil {
+			return nil, fmt.Errorf("decode conversations: %w", err)
+		}
+	}
+	return c, nil
+}
+
+// remember records conv and returns its ID.
+func (c *conversations) remember(conv conversation) int64 {
+	id := conv.id()
+	if c == nil || conv == (conversation{ChatID: id}) {
+		return id
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if known, ok := c.known[id]; ok && known == conv {
+		return id
+	}
+	c.known[id] = conv
+	if err := c.flush(); err != nil && c.logger != nil {
+		c.logger.Error("failed to persist conversations", zap.String("path", c.path), zap.Error(err))
+	}
+	return id
+}
+
+// lookup returns the conversation behind id. IDs that were never derived are
+// plain chats.
+func (c *conversations) lookup(id int64) conversation {
+	if c == nil {
+		return conversation{ChatID: id}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conv, ok := c.known[id]; ok {
+		return conv
+	}
+	return conversation{ChatID: id}
+}
+
+func (c *conversations) flush() error {
+	if c.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(c.known)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.path, data)
+}
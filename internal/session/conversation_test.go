@@ -0,0 +1,85 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+)
+
+func TestConversationID(t *testing.T) {
+	plain := conversation{ChatID: -100123}
+	if plain.id() != -100123 {
+		t.Fatalf("expected plain chats to keep their ID, got %d", plain.id())
+	}
+
+	topic := conversation{ChatID: -100123, Topic: 7}
+	member := conversation{ChatID: -100123, Topic: 7, UserID: 42}
+	if topic.id() == member.id() || topic.id() == plain.id() {
+		t.Fatal("expected topics and members to get their own IDs")
+	}
+	if topic.id() != (conversation{ChatID: -100123, Topic: 7}).id() {
+		t.Fatal("expected derived IDs to be stable")
+	}
+	if topic.id() > -(1 << 62) {
+		t.Fatalf("expected derived ID far below real chat IDs, got %d", topic.id())
+	}
+}
+
+func TestConversationsPersist(t *testing.T) {
+	cfg := &config.Config{Session: config.Session{StoreDir: t.TempDir()}}
+	conversations, err := openConversations(cfg, "telegram", nil)
+	if err != nil {
+		t.Fatalf("openConversations() error = %v", err)
+	}
+
+	id := conversations.remember(conversation{ChatID: -100123, Topic: 7})
+	if conversations.remember(conversation{ChatID: 5}) != 5 {
+		t.Fatal("expected plain chats to pass through")
+	}
+
+	reopened, err := openConversations(cfg, "telegram", nil)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	if conv := reopened.lookup(id); conv.ChatID != -100123 || conv.Topic != 7 {
+		t.Fatalf("unexpected conversation after reopen: %#v", conv)
+	}
+	if conv := reopened.lookup(5); conv.ChatID != 5 || conv.Topic != 0 {
+		t.Fatalf("unexpected plain conversation: %#v", conv)
+	}
+}
+
+func TestRunnerConversationIDPerUser(t *testing.T) {
+	r := &Runner{cfg: &config.Config{Telegram: config.Telegram{PerUserSessions: true}}}
+	group := &tgbotapi.Chat{ID: -100123, Type: "supergroup"}
+
+	alice := r.conversationID(&tgbotapi.Message{Chat: group, From: &tgbotapi.User{ID: 1}}, 0)
+	bob := r.conversationID(&tgbotapi.Message{Chat: group, From: &tgbotapi.User{ID: 2}}, 0)
+	if alice == bob {
+		t.Fatal("expected members to get separate conversations")
+	}
+
+	private := r.conversationID(&tgbotapi.Message{Chat: &tgbotapi.Chat{ID: 1, Type: "private"}, From: &tgbotapi.User{ID: 1}}, 0)
+	if private != 1 {
+		t.Fatalf("expected private chats to keep their ID, got %d", private)
+	}
+}
+
+func TestRunnerAcceptsGroupUsers(t *testing.T) {
+	r := &Runner{cfg: &config.Config{Telegram: config.Telegram{
+		AllowedChatIDs: []int64{-100123},
+		GroupUsers:     map[string][]int64{"-100123": {1}},
+	}}}
+	group := &tgbotapi.Chat{ID: -100123, Type: "supergroup"}
+
+	if !r.accepts(&tgbotapi.Message{Chat: group, From: &tgbotapi.User{ID: 1}}) {
+		t.Error("expected listed member to be accepted")
+	}
+	if r.accepts(&tgbotapi.Message{Chat: group, From: &tgbotapi.User{ID: 2}}) {
+		t.Error("expected unlisted member to be rejected")
+	}
+	if r.accepts(&tgbotapi.Message{Chat: &tgbotapi.Chat{ID: -100999, Type: "group"}, From: &tgbotapi.User{ID: 1}}) {
+		t.Error("expected chat outside allowed_chat_ids to be rejected")
+	}
+}
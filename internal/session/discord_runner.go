@@ -2,10 +2,9 @@ package session
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/bwmarrin/discordgo"
 	"github.com/jomei/notionapi"
@@ -19,14 +18,15 @@ import (
 type DiscordRunner struct {
 	cfg          *config.Config
 	discord      *discordclient.Client
-	notion       *notion.Client
-	github       *github.Client
+	pipeline     *Pipeline
+	chatCommands *Commands
 	stateMachine *StateMachine
-	ctx          context.Context
+	channelsMu   sync.Mutex
+	channels     map[string]int64
 	logger       *zap.Logger
 }
 
-const discordHelpText = "Commands:\n/start - start a new capture session\n/clean - clear the current buffer\n/discard - abandon the current session\n/end - create a Notion page and end session\n/help - show this help"
+var _ Platform = (*DiscordRunner)(nil)
 
 func NewDiscordRunner(cfg *config.Config, logger *zap.Logger) (*DiscordRunner, error) {
 	client, err := discordclient.NewClient(cfg.Discord.Token)
@@ -38,21 +38,61 @@ func NewDiscordRunner(cfg *config.Config, logger *zap.Logger) (*DiscordRunner, e
 		return nil, err
 	}
 
-	return &DiscordRunner{
+	stateMachine, err := openStateMachine(cfg, "discord", logger)
+	if err != nil {
+		return nil, err
+	}
+	outbox, err := openOutbox(cfg, "discord", logger)
+	if err != nil {
+		return nil, err
+	}
+	if pending := outbox.Len(); pending > 0 && logger != nil {
+		logger.Info("restored outbox", zap.String("platform", "discord"), zap.Int("pending", pending))
+	}
+	pages, err := openPageLog(cfg, "discord", logger)
+	if err != nil {
+		return nil, err
+	}
+	if restored := stateMachine.Count(); restored > 0 && logger != nil {
+		logger.Info("restored sessions", zap.String("platform", "discord"), zap.Int("count", restored))
+	}
+
+	r := &DiscordRunner{
 		cfg:          cfg,
 		discord:      client,
-		notion:       notion.NewClient(cfg.Notion.Token),
-		github:       github.NewClient(cfg.GitHub.Token, cfg.GitHub.Repo, cfg.GitHub.BranchForDiscord(), cfg.GitHub.PathPrefix),
-		stateMachine: NewStateMachine(),
+		stateMachine: stateMachine,
+		channels:     make(map[string]int64),
 		logger:       logger,
-	}, nil
+	}
+	githubClient := github.NewClient(cfg.GitHub.Token, cfg.GitHub.Repo, cfg.GitHub.BranchForDiscord(), cfg.GitHub.PathPrefix)
+	r.pipeline = NewPipeline(cfg, r, notion.NewClient(cfg.Notion.Token), githubClient, outbox, pages, logger)
+	r.chatCommands = NewCommands(stateMachine, r.pipeline, logger)
+	return r, nil
 }
 
+// Run serves Discord events until ctx is cancelled. It can be called again
+// after it returns; Close releases the runner afterwards.
 func (r *DiscordRunner) Run(ctx context.Context) error {
-	r.ctx = ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Handlers get this run's ctx through their closures; Run may be called
+	// again while handlers of the previous run are still finishing.
 	session := r.discord.Session()
-	session.AddHandler(r.handleInteraction)
-	session.AddHandler(r.handleMessage)
+	for _, remove := range []func(){
+		session.AddHandler(func(s *discordgo.Session, i *discordgo.InteractionCreate) {
+			r.handleInteraction(ctx, s, i)
+		}),
+		session.AddHandler(func(s *discordgo.Session, e *discordgo.Event) {
+			r.handleEvent(ctx, s, e)
+		}),
+		session.AddHandler(r.handleMessageDelete),
+	} {
+		defer remove()
+	}
+
+	go r.pipeline.RunOutbox(ctx)
+	go NewIdleSweeper(r.cfg.Session, r.stateMachine, r.pipeline, r.logger).Run(ctx)
 
 	if err := r.discord.Open(); err != nil {
 		return err
@@ -69,19 +109,42 @@ func (r *DiscordRunner) Run(ctx context.Context) error {
 	return nil
 }
 
+// Close releases the session store.
+func (r *DiscordRunner) Close() error {
+	return r.stateMachine.Close()
+}
+
 func (r *DiscordRunner) commands() []*discordgo.ApplicationCommand {
 	dmPermission := true
 
 	return []*discordgo.ApplicationCommand{
-		{Name: "start", Description: "Start a new capture session", DMPermission: &dmPermission},
+		{Name: "start", Description: "Start a new capture session", DMPermission: &dmPermission, Options: sessionNameOption(false)},
+		{Name: "switch", Description: "Switch the active session", DMPermission: &dmPermission, Options: sessionNameOption(true)},
+		{Name: "sessions", Description: "List sessions", DMPermission: &dmPermission},
+		{Name: "preview", Description: "Show what is buffered", DMPermission: &dmPermission},
+		{Name: "undo", Description: "Remove the last n blocks", DMPermission: &dmPermission, Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionInteger, Name: "count", Description: "Number of blocks to remove", MinValue: &minUndoCount},
+		}},
 		{Name: "clean", Description: "Clear the current buffer", DMPermission: &dmPermission},
-		{Name: "discard", Description: "Discard the current session", DMPermission: &dmPermission},
-		{Name: "end", Description: "Save to Notion and end session", DMPermission: &dmPermission},
+		{Name: "discard", Description: "Discard a session", DMPermission: &dmPermission, Options: sessionNameOption(false)},
+		{Name: "end", Description: "Save to Notion and end session", DMPermission: &dmPermission, Options: sessionNameOption(false)},
+		{Name: "append", Description: "Append the session to an existing page", DMPermission: &dmPermission, Options: []*discordgo.ApplicationCommandOption{
+			{Type: discordgo.ApplicationCommandOptionString, Name: "page", Description: "Page link or id; defaults to the last saved page"},
+		}},
+		{Name: "last", Description: "Show the most recently saved page", DMPermission: &dmPermission},
 		{Name: "help", Description: "Show available commands", DMPermission: &dmPermission},
 	}
 }
 
-func (r *DiscordRunner) handleInteraction(s *discordgo.Session, i *discordgo.InteractionCreate) {
+var minUndoCount = 1.0
+
+func sessionNameOption(required bool) []*discordgo.ApplicationCommandOption {
+	return []*discordgo.ApplicationCommandOption{
+		{Type: discordgo.ApplicationCommandOptionString, Name: "name", Description: "Session name", Required: required},
+	}
+}
+
+func (r *DiscordRunner) handleInteraction(ctx context.Context, s *discordgo.Session, i *discordgo.InteractionCreate) {
 	if i.Type != discordgo.InteractionApplicationCommand {
 		return
 	}
@@ -103,50 +166,9 @@ func (r *DiscordRunner) handleInteraction(s *discordgo.Session, i *discordgo.Int
 		return
 	}
 
-	command := i.ApplicationCommandData().Name
-	response := ""
-
-	switch command {
-	case "help":
-		response = discordHelpText
-	case "start":
-		if !r.stateMachine.IsActive(chatID) {
-			r.stateMachine.StartSession(chatID)
-			response = "Session started. Send messages or images, then /end to save."
-		} else {
-			response = "Session already active. Use /clean to clear or /end to save."
-		}
-	case "clean":
-		if r.stateMachine.IsActive(chatID) {
-			r.stateMachine.ClearSession(chatID)
-			response = "Buffer cleared. Continue sending messages."
-		} else {
-			response = "No active session. Use /start first."
-		}
-	case "discard":
-		if r.stateMachine.IsActive(chatID) {
-			r.stateMachine.DiscardSession(chatID)
-			response = "Session discarded."
-		} else {
-			response = "No active session to discard."
-		}
-	case "end":
-		if r.stateMachine.IsActive(chatID) {
-			session, ok := r.stateMachine.EndSession(chatID)
-			if ok {
-				if err := r.createNotionPage(r.ctx, session); err != nil {
-					response = "Failed to save. Check logs for details."
-					if r.logger != nil {
-						r.logger.Error("failed to create notion page", zap.String("user_id", userID), zap.Error(err))
-					}
-				} else {
-					response = "Saved to Notion."
-				}
-			}
-		} else {
-			response = "No active session. Use /start first."
-		}
-	default:
+	data := i.ApplicationCommandData()
+	response, ok := r.chatCommands.Execute(ctx, chatID, data.Name, interactionArgument(data))
+	if !ok {
 		return
 	}
 
@@ -155,44 +177,186 @@ func (r *DiscordRunner) handleInteraction(s *discordgo.Session, i *discordgo.Int
 	}
 }
 
-func (r *DiscordRunner) handleMessage(s *discordgo.Session, m *discordgo.MessageCreate) {
-	if m.Author == nil || m.Author.Bot {
+// handleEvent routes message events. It works on the raw gateway event so the
+// attachment descriptions used as image captions, which discordgo drops, are
+// available too.
+func (r *DiscordRunner) handleEvent(ctx context.Context, s *discordgo.Session, e *discordgo.Event) {
+	switch event := e.Struct.(type) {
+	case *discordgo.MessageCreate:
+		r.handleMessage(ctx, s, event, discordclient.AttachmentDescriptions(e.RawData))
+	case *discordgo.MessageUpdate:
+		r.handleMessageUpdate(s, event, discordclient.AttachmentDescriptions(e.RawData))
+	}
+}
+
+func (r *DiscordRunner) handleMessage(ctx context.Context, s *discordgo.Session, m *discordgo.MessageCreate, descriptions map[string]string) {
+	chatID, ok := r.messageChatID(m.Message)
+	if !ok {
 		return
 	}
-	if m.GuildID != "" {
+	r.rememberChannel(m.ChannelID, chatID)
+	blocks := r.messageBlocks(m.Message, descriptions)
+
+	switch r.cfg.Session.CaptureMode {
+	case config.CaptureModeJournal:
+		r.pipeline.Journal(ctx, chatID, blocks)
+		return
+	case config.CaptureModeInstant:
+		if response := r.pipeline.Instant(ctx, chatID, blocks); response != "" {
+			r.sendUserMessage(chatID, response)
+		}
 		return
 	}
-	if !discordclient.IsAllowedUser(r.cfg.Discord.AllowedUserIDs, m.Author.ID) {
+
+	if !r.stateMachine.IsActive(chatID) {
+		r.stateMachine.StartSession(chatID)
+	}
+
+	for _, block := range blocks {
+		r.stateMachine.AppendBlock(chatID, block)
+	}
+}
+
+// handleMessageUpdate replaces the blocks captured from an edited DM.
+func (r *DiscordRunner) handleMessageUpdate(s *discordgo.Session, m *discordgo.MessageUpdate, descriptions map[string]string) {
+	if m.Message == nil {
+		return
+	}
+	chatID, ok := r.messageChatID(m.Message)
+	if !ok {
 		return
 	}
 
+	if r.stateMachine.ReplaceMessage(chatID, m.ID, r.messageBlocks(m.Message, descriptions)) && r.logger != nil {
+		r.logger.Debug("updated edited message", zap.String("user_id", m.Author.ID), zap.String("message_id", m.ID))
+	}
+}
+
+// handleMessageDelete drops the blocks captured from a deleted DM. Delete
+// events carry no author, so the chat is resolved from the DM channel.
+func (r *DiscordRunner) handleMessageDelete(s *discordgo.Session, m *discordgo.MessageDelete) {
+	if m.Message == nil || m.GuildID != "" {
+		return
+	}
+
+	chatID, ok := r.channelChatID(m.ChannelID)
+	if !ok && m.BeforeDelete != nil {
+		chatID, ok = r.messageChatID(m.BeforeDelete)
+	}
+	if !ok {
+		return
+	}
+
+	if r.stateMachine.RemoveMessage(chatID, m.ID) && r.logger != nil {
+		r.logger.Debug("removed deleted message", zap.Int64("chat_id", chatID), zap.String("message_id", m.ID))
+	}
+}
+
+// messageChatID maps an allowed user's DM to the chat ID sessions are keyed by.
+func (r *DiscordRunner) messageChatID(m *discordgo.Message) (int64, bool) {
+	if m.Author == nil || m.Author.Bot {
+		return 0, false
+	}
+	if m.GuildID != "" {
+		return 0, false
+	}
+	if !discordclient.IsAllowedUser(r.cfg.Discord.AllowedUserIDs, m.Author.ID) {
+		return 0, false
+	}
+
 	chatID, err := strconv.ParseInt(m.Author.ID, 10, 64)
 	if err != nil {
-		return
+		return 0, false
 	}
-	if !r.stateMachine.IsActive(chatID) {
-		r.stateMachine.StartSession(chatID)
+	return chatID, true
+}
+
+func (r *DiscordRunner) rememberChannel(channelID string, chatID int64) {
+	r.channelsMu.Lock()
+	defer r.channelsMu.Unlock()
+
+	r.channels[channelID] = chatID
+}
+
+func (r *DiscordRunner) channelChatID(channelID string) (int64, bool) {
+	r.channelsMu.Lock()
+	defer r.channelsMu.Unlock()
+
+	chatID, ok := r.channels[channelID]
+	return chatID, ok
+}
+
+// messageBlocks converts a DM into blocks, preceded by the message it replies
+// to when reply context is enabled.
+func (r *DiscordRunner) messageBlocks(msg *discordgo.Message, descriptions map[string]string) []Block {
+	blocks := messageBlocks(msg, descriptions)
+	if !r.cfg.Session.ReplyContext || msg.ReferencedMessage == nil {
+		return blocks
+	}
+
+	quote, ok := discordReplyBlock(msg.ReferencedMessage, msg.ID)
+	if !ok {
+		return blocks
+	}
+	return append([]Block{quote}, blocks...)
+}
+
+// discordReplyBlock quotes the message a reply answers.
+func discordReplyBlock(reply *discordgo.Message, messageID string) (QuoteBlock, bool) {
+	content := strings.TrimSpace(discordclient.NormalizeContent(reply.Content, reply.Mentions))
+	richText := discordclient.ContentToRichText(content)
+	if len(richText) == 0 && len(reply.Attachments) > 0 {
+		richText = []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "[attachment: " + reply.Attachments[0].Filename + "]"}}}
 	}
 
-	r.collectMessage(m, chatID)
+	author := ""
+	if reply.Author != nil {
+		author = reply.Author.Username
+	}
+	return replyQuote(author, richText, messageID)
 }
 
-func (r *DiscordRunner) collectMessage(msg *discordgo.MessageCreate, chatID int64) {
+// messageBlocks converts a DM into blocks. descriptions holds attachment alt
+// texts by attachment ID; they become image captions.
+func messageBlocks(msg *discordgo.Message, descriptions map[string]string) []Block {
+	blocks := make([]Block, 0, 1+len(msg.Attachments))
+
 	content := strings.TrimSpace(discordclient.NormalizeContent(msg.Content, msg.Mentions))
 	if content != "" {
 		if code := extractDiscordCodeBlock(content); code != nil {
-			r.stateMachine.AppendBlock(chatID, *code)
+			code.MessageID = msg.ID
+			blocks = append(blocks, *code)
 		} else if richText := discordclient.ContentToRichText(content); len(richText) > 0 {
-			r.stateMachine.AppendBlock(chatID, TextBlock{RichText: richText})
+			blocks = append(blocks, TextBlock{RichText: richText, MessageID: msg.ID})
 		}
 	}
 
+	// Several attachments on one message are kept together like an album.
+	album := ""
+	if len(msg.Attachments) > 1 {
+		album = msg.ID
+	}
 	for _, attachment := range msg.Attachments {
-		if !isImageAttachment(attachment) {
+		if attachment == nil {
 			continue
 		}
-		r.stateMachine.AppendBlock(chatID, ImageBlock{FileURL: attachment.URL, Filename: attachment.Filename})
-	}
+		if isImageAttachment(attachment) {
+			blocks = append(blocks, ImageBlock{FileURL: attachment.URL, Filename: attachment.Filename, Caption: descriptions[attachment.ID], MessageID: msg.ID, Album: album})
+			continue
+		}
+		blocks = append(blocks, FileBlock{
+			FileURL:   attachment.URL,
+			Filename:  attachment.Filename,
+			MimeType:  attachment.ContentType,
+			Size:      int64(attachment.Size),
+			Media:     attachmentMedia(attachment.ContentType),
+			Caption:   descriptions[attachment.ID],
+			MessageID: msg.ID,
+			Album:     album,
+		})
+	}
+
+	return blocks
 }
 
 func (r *DiscordRunner) respondInteraction(s *discordgo.Session, i *discordgo.InteractionCreate, content string, ephemeral bool) {
@@ -234,84 +398,20 @@ func (r *DiscordRunner) sendUserMessage(chatID int64, message string) {
 	}
 }
 
-func (r *DiscordRunner) createNotionPage(ctx context.Context, session *Session) error {
-	loc, err := r.cfg.Title.Location()
-	if err != nil {
-		return err
-	}
+func (r *DiscordRunner) Origin() string {
+	return "Discord"
+}
 
-	blocks := make([]notionapi.Block, 0, len(session.Blocks))
-
-	for _, block := range session.Blocks {
-		switch b := block.(type) {
-		case TextBlock:
-			richTexts := splitRichTextEntries(b.RichText)
-			for _, chunk := range chunkRichText(richTexts, notionRichTextBlockLimit) {
-				blocks = append(blocks, &notionapi.ParagraphBlock{
-					BasicBlock: notionapi.BasicBlock{Object: "block", Type: "paragraph"},
-					Paragraph:  notionapi.Paragraph{RichText: chunk},
-				})
-			}
-		case CodeBlock:
-			language := b.Language
-			if language == "" {
-				language = "plain text"
-			}
-			richTexts := splitRichTextEntries([]notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: b.Content}}})
-			for _, chunk := range chunkRichText(richTexts, notionRichTextBlockLimit) {
-				blocks = append(blocks, &notionapi.CodeBlock{
-					BasicBlock: notionapi.BasicBlock{Object: "block", Type: "code"},
-					Code: notionapi.Code{
-						RichText: chunk,
-						Language: language,
-					},
-				})
-			}
-		case ImageBlock:
-			if b.FileURL == "" {
-				continue
-			}
-			data, extension, err := downloadImage(ctx, b.FileURL)
-			if err != nil {
-				if errors.Is(err, ErrImageTooLarge) {
-					r.sendUserMessage(session.ChatID, imageTooLargeMessage)
-					blocks = append(blocks, imagePlaceholderBlock(imageTooLargeMessage))
-					continue
-				}
-				if errors.Is(err, ErrUnsupportedImageType) {
-					r.sendUserMessage(session.ChatID, imageUnsupportedTypeMessage)
-					blocks = append(blocks, imagePlaceholderBlock(imageUnsupportedTypeMessage))
-					continue
-				}
-				return err
-			}
-
-			rawURL, err := r.github.UploadImage(ctx, data, extension)
-			if err != nil {
-				return err
-			}
-
-			image := &notionapi.ImageBlock{
-				BasicBlock: notionapi.BasicBlock{Object: "block", Type: "image"},
-				Image:      notionapi.Image{External: &notionapi.FileObject{URL: rawURL}},
-			}
-			if b.Caption != "" {
-				image.Image.Caption = []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: b.Caption}}}
-			}
-			blocks = append(blocks, image)
-		}
-	}
+func (r *DiscordRunner) ResolveMedia(ctx context.Context, fileID string, fileURL string) (string, error) {
+	return fileURL, nil
+}
 
-	if len(blocks) == 0 {
-		return fmt.Errorf("no blocks to save")
-	}
+func (r *DiscordRunner) Notify(chatID int64, message string) {
+	r.sendUserMessage(chatID, message)
+}
 
-	title := r.cfg.Title.FormatTime(loc)
-	if r.logger != nil {
-		r.logger.Info("creating notion page", zap.String("origin_property", r.cfg.Notion.OriginProperty), zap.String("origin", "Discord"))
-	}
-	_, err = r.notion.CreatePage(ctx, r.cfg.Notion.DatabaseID, r.cfg.Notion.TitleProperty, r.cfg.Notion.OriginProperty, title, "Discord", blocks)
-	return err
+func (r *DiscordRunner) ChatField(chatID int64) zap.Field {
+	return zap.String("user_id", strconv.FormatInt(chatID, 10))
 }
 
 func extractDiscordCodeBlock(content string) *CodeBlock {
@@ -346,6 +446,37 @@ func isImageAttachment(attachment *discordgo.MessageAttachment) bool {
 	return attachment.Width > 0 && attachment.Height > 0
 }
 
+// attachmentMedia infers the kind of a non-image attachment from its content
+// type.
+func attachmentMedia(contentType string) string {
+	switch {
+	case strings.HasPrefix(contentType, "video/"):
+		return MediaVideo
+	case strings.HasPrefix(contentType, "audio/"):
+		return MediaAudio
+	default:
+		return MediaDocument
+	}
+}
+
+// interactionArgument returns the first option of a slash command as text, the
+// same form a Telegram command argument takes.
+func interactionArgument(data discordgo.ApplicationCommandInteractionData) string {
+	if len(data.Options) == 0 {
+		return ""
+	}
+
+	option := data.Options[0]
+	switch option.Type {
+	case discordgo.ApplicationCommandOptionString:
+		return option.StringValue()
+	case discordgo.ApplicationCommandOptionInteger:
+		return strconv.FormatInt(option.IntValue(), 10)
+	default:
+		return ""
+	}
+}
+
 func interactionUserID(i *discordgo.InteractionCreate) string {
 	if i.User != nil {
 		return i.User.ID
@@ -0,0 +1,30 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+)
+
+func TestDiscordMessageBlocksReplyContext(t *testing.T) {
+	r := &DiscordRunner{cfg: &config.Config{Session: config.Session{ReplyContext: true}}}
+	msg := &discordgo.Message{
+		ID:      "2",
+		Content: "yes",
+		ReferencedMessage: &discordgo.Message{
+			ID:      "1",
+			Content: "coffee?",
+			Author:  &discordgo.User{Username: "ada"},
+		},
+	}
+
+	blocks := r.messageBlocks(msg, nil)
+	if len(blocks) != 2 {
+		t.Fatalf("expected quote and text blocks, got %#v", blocks)
+	}
+	quote, ok := blocks[0].(QuoteBlock)
+	if !ok || plainText(quote.RichText) != "ada: coffee?" || quote.Source() != "2" {
+		t.Fatalf("unexpected reply quote: %#v", blocks[0])
+	}
+}
@@ -5,8 +5,13 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"time"
 
@@ -25,6 +30,35 @@ var (
 	ErrUnsupportedImageType = errors.New("unsupported image type")
 )
 
+var (
+	maxFileSizeBytes int64 = 20 * 1024 * 1024
+	allowedFileTypes       = []string{"*/*"}
+)
+
+var (
+	fileTooLargeMessage        = "File skipped: exceeds 20MB limit."
+	fileUnsupportedTypeMessage = "File skipped: unsupported file type."
+)
+
+var (
+	ErrFileTooLarge        = errors.New("file exceeds size limit")
+	ErrUnsupportedFileType = errors.New("unsupported file type")
+)
+
+// fileExtensions covers common media types that the mime package does not
+// know without a system mime table.
+var fileExtensions = map[string]string{
+	"application/pdf": ".pdf",
+	"application/zip": ".zip",
+	"audio/mpeg":      ".mp3",
+	"audio/mp4":       ".m4a",
+	"audio/ogg":       ".ogg",
+	"text/plain":      ".txt",
+	"video/mp4":       ".mp4",
+	"video/quicktime": ".mov",
+	"video/webm":      ".webm",
+}
+
 var mediaHTTPClient = &http.Client{
 	Timeout: 30 * time.Second,
 	Transport: &http.Transport{
@@ -40,6 +74,11 @@ var mediaHTTPClient = &http.Client{
 	},
 }
 
+// localFilesRoot is the data directory of a local-mode Bot API server. The
+// file:// URLs such a server hands out are only read from inside it, and not
+// at all while it is empty.
+var localFilesRoot string
+
 var supportedImageTypes = map[string]string{
 	"image/jpeg": ".jpg",
 	"image/png":  ".png",
@@ -85,31 +124,167 @@ func ConfigureMedia(cfg *config.Config) error {
 		return fmt.Errorf("media.allowed_image_types is required")
 	}
 	allowedImageTypes = allowed
+
+	if cfg.Media.MaxFileSizeMB > 0 {
+		maxFileSizeBytes = cfg.Media.MaxFileSizeMB * 1024 * 1024
+		fileTooLargeMessage = fmt.Sprintf("File skipped: exceeds %dMB limit.", cfg.Media.MaxFileSizeMB)
+	}
+	if len(cfg.Media.AllowedFileTypes) > 0 {
+		allowedFileTypes = cfg.Media.AllowedFileTypes
+	}
+	localFilesRoot = ""
+	if cfg.Telegram.LocalMode {
+		root, err := filepath.Abs(cfg.Telegram.LocalFilesDir)
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			return fmt.Errorf("telegram.local_files_dir is invalid: %w", err)
+		}
+		localFilesRoot = root
+	}
 	return nil
 }
 
-func downloadImage(ctx context.Context, url string) ([]byte, string, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+// fileTypeAllowed matches contentType against the configured file types,
+// which may end in "/*" or be "*/*".
+func fileTypeAllowed(contentType string) bool {
+	contentType = baseMediaType(contentType)
+	for _, pattern := range allowedFileTypes {
+		if pattern == "*/*" || pattern == contentType {
+			return true
+		}
+		if prefix, ok := strings.CutSuffix(pattern, "*"); ok && strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// openMedia starts reading the media at rawURL and returns its size, or -1
+// when unknown. file:// URLs come from a local-mode Bot API server and are
+// read by openLocalFile; everything else is fetched over HTTP.
+func openMedia(ctx context.Context, rawURL string) (io.ReadCloser, int64, error) {
+	if strings.HasPrefix(rawURL, "file://") {
+		return openLocalFile(rawURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := mediaHTTPClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// openLocalFile opens a file handed out by a local-mode Bot API server. Only
+// regular files inside localFilesRoot are read, after resolving symlinks.
+func openLocalFile(rawURL string) (io.ReadCloser, int64, error) {
+	if localFilesRoot == "" {
+		return nil, 0, fmt.Errorf("local files are not enabled")
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	path, err := filepath.EvalSymlinks(filepath.Clean(filepath.FromSlash(parsed.Path)))
+	if err != nil {
+		return nil, 0, err
+	}
+	rel, err := filepath.Rel(localFilesRoot, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, 0, fmt.Errorf("local file %s is outside %s", path, localFilesRoot)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	if !info.Mode().IsRegular() {
+		file.Close()
+		return nil, 0, fmt.Errorf("local file %s is not a regular file", path)
+	}
+	return file, info.Size(), nil
+}
+
+// downloadFile fetches a document, audio or video file within the size limit.
+// declaredType is the MIME type reported by the platform; the content is
+// sniffed when it is empty.
+func downloadFile(ctx context.Context, url string, declaredType string) ([]byte, string, error) {
+	body, size, err := openMedia(ctx, url)
 	if err != nil {
 		return nil, "", err
 	}
+	defer body.Close()
 
-	resp, err := mediaHTTPClient.Do(req)
+	if size > maxFileSizeBytes {
+		return nil, "", ErrFileTooLarge
+	}
+
+	data, err := io.ReadAll(&io.LimitedReader{R: body, N: maxFileSizeBytes + 1})
 	if err != nil {
 		return nil, "", err
 	}
-	defer resp.Body.Close()
+	if int64(len(data)) > maxFileSizeBytes {
+		return nil, "", ErrFileTooLarge
+	}
 
-	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
-		_, _ = io.Copy(io.Discard, resp.Body)
-		return nil, "", fmt.Errorf("download failed with status %d", resp.StatusCode)
+	contentType := baseMediaType(declaredType)
+	if contentType == "" {
+		contentType = baseMediaType(http.DetectContentType(data))
+	}
+	if !fileTypeAllowed(contentType) {
+		return nil, "", ErrUnsupportedFileType
+	}
+	return data, contentType, nil
+}
+
+// fileExtension keeps the extension of filename, or derives one from the
+// content type.
+func fileExtension(filename string, contentType string) string {
+	if ext := strings.ToLower(path.Ext(filename)); ext != "" {
+		return ext
+	}
+	if ext, ok := fileExtensions[contentType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(contentType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+func baseMediaType(contentType string) string {
+	base, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(base))
+}
+
+func downloadImage(ctx context.Context, url string) ([]byte, string, error) {
+	body, size, err := openMedia(ctx, url)
+	if err != nil {
+		return nil, "", err
 	}
+	defer body.Close()
 
-	if resp.ContentLength > maxImageSizeBytes {
+	if size > maxImageSizeBytes {
 		return nil, "", ErrImageTooLarge
 	}
 
-	limited := &io.LimitedReader{R: resp.Body, N: maxImageSizeBytes + 1}
+	limited := &io.LimitedReader{R: body, N: maxImageSizeBytes + 1}
 	header := make([]byte, 512)
 	n, err := io.ReadFull(limited, header)
 	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
@@ -5,6 +5,9 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 )
@@ -65,3 +68,125 @@ func TestDownloadImage_PNG(t *testing.T) {
 		t.Fatalf("data length = %d, want %d", len(data), len(pngBytes))
 	}
 }
+
+func TestDownloadFile_TooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.FormatInt(maxFileSizeBytes+1, 10))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	_, _, err := downloadFile(context.Background(), server.URL, "application/pdf")
+	if !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge, got %v", err)
+	}
+}
+
+func TestDownloadFile_SniffsType(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("%PDF-1.4\n"))
+	}))
+	defer server.Close()
+
+	_, contentType, err := downloadFile(context.Background(), server.URL, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if contentType != "application/pdf" {
+		t.Fatalf("contentType = %q, want application/pdf", contentType)
+	}
+}
+
+func TestDownloadFile_UnsupportedType(t *testing.T) {
+	previous := allowedFileTypes
+	allowedFileTypes = []string{"audio/*", "application/pdf"}
+	defer func() { allowedFileTypes = previous }()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("data"))
+	}))
+	defer server.Close()
+
+	_, _, err := downloadFile(context.Background(), server.URL, "video/mp4")
+	if !errors.Is(err, ErrUnsupportedFileType) {
+		t.Fatalf("expected ErrUnsupportedFileType, got %v", err)
+	}
+	if _, _, err := downloadFile(context.Background(), server.URL, "audio/ogg; codecs=opus"); err != nil {
+		t.Fatalf("expected audio to be allowed, got %v", err)
+	}
+}
+
+func TestFileExtension(t *testing.T) {
+	cases := []struct {
+		filename    string
+		contentType string
+		want        string
+	}{
+		{"Report.PDF", "application/pdf", ".pdf"},
+		{"", "audio/ogg", ".ogg"},
+		{"", "video/mp4", ".mp4"},
+		{"", "application/x-unknown", ".bin"},
+	}
+	for _, tc := range cases {
+		if got := fileExtension(tc.filename, tc.contentType); got != tc.want {
+			t.Errorf("fileExtension(%q, %q) = %q, want %q", tc.filename, tc.contentType, got, tc.want)
+		}
+	}
+}
+
+func TestDownloadFile_LocalPath(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatalf("resolve temp dir: %v", err)
+	}
+	path := filepath.Join(root, "documents", "notes.pdf")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("%PDF-1.4\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.pdf")
+	if err := os.WriteFile(outside, []byte("%PDF-1.4\n"), 0o644); err != nil {
+		t.Fatalf("write file: %v", err)
+	}
+	link := func(path string) string {
+		return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+	}
+
+	if _, _, err := downloadFile(context.Background(), link(path), ""); err == nil {
+		t.Fatal("expected local files to be refused outside local mode")
+	}
+
+	previous := localFilesRoot
+	localFilesRoot = root
+	defer func() { localFilesRoot = previous }()
+
+	data, contentType, err := downloadFile(context.Background(), link(path), "")
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if contentType != "application/pdf" || string(data) != "%PDF-1.4\n" {
+		t.Fatalf("unexpected download %q %q", contentType, data)
+	}
+
+	escaping := link(filepath.Join(root, "..", filepath.Base(filepath.Dir(outside)), "secret.pdf"))
+	for _, target := range []string{link(outside), escaping} {
+		if _, _, err := downloadFile(context.Background(), target, ""); err == nil {
+			t.Fatalf("expected %s outside the data directory to be refused", target)
+		}
+	}
+
+	if err := os.Symlink(outside, filepath.Join(root, "link.pdf")); err == nil {
+		if _, _, err := downloadFile(context.Background(), link(filepath.Join(root, "link.pdf")), ""); err == nil {
+			t.Fatal("expected a symlink out of the data directory to be refused")
+		}
+	}
+
+	previousMax := maxFileSizeBytes
+	maxFileSizeBytes = 4
+	defer func() { maxFileSizeBytes = previousMax }()
+	if _, _, err := downloadFile(context.Background(), link(path), ""); !errors.Is(err, ErrFileTooLarge) {
+		t.Fatalf("expected ErrFileTooLarge, got %v", err)
+	}
+}
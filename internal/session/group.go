@@ -0,0 +1,72 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"github.com/nerdneilsfield/telenotion-bot/internal/tgclient"
+)
+
+// accepts reports whether msg comes from an allowed chat and, in groups with
+// a user allowlist, from a listed member.
+func (r *Runner) accepts(msg *tgbotapi.Message) bool {
+	if msg.Chat == nil || !r.telegram.IsAllowedChat(r.cfg.Telegram.AllowedChatIDs, msg.Chat.ID) {
+		return false
+	}
+	if msg.Chat.IsPrivate() {
+		return true
+	}
+	return msg.From != nil && r.cfg.Telegram.UserAllowed(msg.Chat.ID, msg.From.ID)
+}
+
+// conversationID returns the session chat ID for msg. Groups are split by
+// forum topic and, with per_user_sessions, by member.
+func (r *Runner) conversationID(msg *tgbotapi.Message, topic int) int64 {
+	conv := conversation{ChatID: msg.Chat.ID}
+	if !msg.Chat.IsPrivate() {
+		conv.Topic = topic
+		if r.cfg.Telegram.PerUserSessions && msg.From != nil {
+			conv.UserID = msg.From.ID
+		}
+	}
+	return r.conversations.remember(conv)
+}
+
+// addressedToBot reports whether a command is meant for this bot: either
+// without a suffix or as /command@thisbot.
+func (r *Runner) addressedToBot(msg *tgbotapi.Message) bool {
+	_, target, ok := strings.Cut(msg.CommandWithAt(), "@")
+	return !ok || strings.EqualFold(target, r.telegram.Username())
+}
+
+// triggered reports whether a message should be captured. With
+// group_trigger = "mention", group messages only count when they mention the
+// bot or reply to it, which also works with privacy mode on.
+func (r *Runner) triggered(msg *tgbotapi.Message) bool {
+	if msg.Chat.IsPrivate() || r.cfg.Telegram.GroupTrigger != config.GroupTriggerMention {
+		return true
+	}
+
+	botID := r.telegram.UserID()
+	if reply := msg.ReplyToMessage; reply != nil && reply.From != nil && reply.From.ID == botID {
+		return true
+	}
+	username := r.telegram.Username()
+	return tgclient.Mentions(msg.Text, msg.Entities, username, botID) ||
+		tgclient.Mentions(msg.Caption, msg.CaptionEntities, username, botID)
+}
+
+// withoutBotMention drops the leading @bot that addressed a group message, so
+// it does not end up in the page.
+func (r *Runner) withoutBotMention(msg *tgbotapi.Message) *tgbotapi.Message {
+	if msg.Chat.IsPrivate() {
+		return msg
+	}
+
+	username := r.telegram.Username()
+	stripped := *msg
+	stripped.Text, stripped.Entities = tgclient.StripMention(msg.Text, msg.Entities, username)
+	stripped.Caption, stripped.CaptionEntities = tgclient.StripMention(msg.Caption, msg.CaptionEntities, username)
+	return &stripped
+}
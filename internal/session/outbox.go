@@ -0,0 +1,315 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"go.uber.org/zap"
+)
+
+// SaveFunc writes a finished session to Notion and returns the page it went
+// to.
+type SaveFunc func(ctx context.Context, session *Session) (SavedPage, error)
+
+// NotifyFunc tells the originating chat about the fate of a queued save.
+type NotifyFunc func(chatID int64, message string)
+
+type outboxEntry struct {
+	ID          string         `json:"id"`
+	ChatID      int64          `json:"chat_id"`
+	Session     *storedSession `json:"session"`
+	Attempts    int            `json:"attempts"`
+	NextAttempt time.Time      `json:"next_attempt"`
+	LastError   string         `json:"last_error,omitempty"`
+	CreatedAt   time.Time      `json:"created_at"`
+}
+
+// Outbox queues sessions whose save failed and retries them in the
+// background with exponential backoff. When path is set the queue is written
+// to disk on every change so pending saves survive restarts.
+type Outbox struct {
+	mu      sync.Mutex
+	path    string
+	entries []*outboxEntry
+	policy  config.Outbox
+	logger  *zap.Logger
+	wake    chan struct{}
+	now     func() time.Time
+}
+
+func OpenOutbox(path string, policy config.Outbox, logger *zap.Logger) (*Outbox, error) {
+	o := &Outbox{
+		path:   path,
+		policy: policy,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+		now:    time.Now,
+	}
+	if path == "" {
+		return o, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return o, nil
+		}
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &o.entries); err != nil {
+			return nil, fmt.Errorf("decode outbox: %w", err)
+		}
+	}
+	return o, nil
+}
+
+// openOutbox places the outbox next to the session journal, or keeps it in
+// memory when session.store_dir is not configured.
+func openOutbox(cfg *config.Config, name string, logger *zap.Logger) (*Outbox, error) {
+	path := ""
+	if cfg.Session.StoreDir != "" {
+		path = filepath.Join(cfg.Session.StoreDir, name+"-outbox.json")
+	}
+
+	outbox, err := OpenOutbox(path, cfg.Outbox, logger)
+	if err != nil {
+		return nil, fmt.Errorf("open outbox: %w", err)
+	}
+	return outbox, nil
+}
+
+// Enqueue schedules a retry for session after its first save attempt failed.
+func (o *Outbox) Enqueue(session *Session, cause error) error {
+	stored, err := encodeSession(session)
+	if err != nil {
+		return err
+	}
+
+	now := o.now()
+	entry := &outboxEntry{
+		ID:          fmt.Sprintf("%d-%d", session.ChatID, now.UnixNano()),
+		ChatID:      session.ChatID,
+		Session:     stored,
+		Attempts:    1,
+		NextAttempt: now.Add(o.backoff(1)),
+		CreatedAt:   now,
+	}
+	if cause != nil {
+		entry.LastError = cause.Error()
+	}
+
+	o.mu.Lock()
+	o.entries = append(o.entries, entry)
+	err = o.flush()
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	o.signal()
+	return nil
+}
+
+// Len returns the number of queued saves.
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return len(o.entries)
+}
+
+// Run retries due entries until ctx is cancelled.
+func (o *Outbox) Run(ctx context.Context, save SaveFunc, notify NotifyFunc) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		case <-o.wake:
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+		}
+
+		o.processDue(ctx, save, notify)
+
+		timer.Reset(o.untilNext())
+	}
+}
+
+func (o *Outbox) processDue(ctx context.Context, save SaveFunc, notify NotifyFunc) {
+	for _, entry := range o.due() {
+		if ctx.Err() != nil {
+			return
+		}
+
+		session, err := decodeSession(entry.Session)
+		if err != nil {
+			o.finish(entry)
+			if o.logger != nil {
+				o.logger.Error("dropping undecodable outbox entry", zap.String("id", entry.ID), zap.Error(err))
+			}
+			continue
+		}
+
+		saved, err := save(ctx, session)
+		if err == nil {
+			o.finish(entry)
+			notify(entry.ChatID, fmt.Sprintf("%s (after %d attempts)", saved.message(), entry.Attempts+1))
+			if o.logger != nil {
+				o.logger.Info("outbox save succeeded", zap.String("id", entry.ID), zap.Int64("chat_id", entry.ChatID))
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if o.retry(entry, session, err) {
+			if o.logger != nil {
+				o.logger.Warn("outbox save failed, will retry", zap.String("id", entry.ID), zap.Int64("chat_id", entry.ChatID), zap.Int("attempts", entry.Attempts), zap.Error(err))
+			}
+			continue
+		}
+
+		notify(entry.ChatID, fmt.Sprintf("Failed to save to Notion after %d attempts. The session was dropped; check logs for details.", entry.Attempts))
+		if o.logger != nil {
+			o.logger.Error("outbox save permanently failed", zap.String("id", entry.ID), zap.Int64("chat_id", entry.ChatID), zap.Int("attempts", entry.Attempts), zap.Error(err))
+		}
+	}
+}
+
+func (o *Outbox) due() []*outboxEntry {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := o.now()
+	due := make([]*outboxEntry, 0, len(o.entries))
+	for _, entry := range o.entries {
+		if !entry.NextAttempt.After(now) {
+			due = append(due, entry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+	return due
+}
+
+// retry records a failed attempt and reports whether the entry stays queued.
+// The session is stored again, since a partial write moves where the next
+// attempt resumes.
+func (o *Outbox) retry(entry *outboxEntry, session *Session, cause error) bool {
+	stored, err := encodeSession(session)
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if err == nil {
+		entry.Session = stored
+	}
+	entry.Attempts++
+	entry.LastError = cause.Error()
+	keep := entry.Attempts < o.policy.MaxAttempts
+	if keep {
+		entry.NextAttempt = o.now().Add(o.backoff(entry.Attempts))
+	} else {
+		o.remove(entry.ID)
+	}
+	o.flushLogged()
+	return keep
+}
+
+func (o *Outbox) finish(entry *outboxEntry) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.remove(entry.ID)
+	o.flushLogged()
+}
+
+func (o *Outbox) remove(id string) {
+	for i, entry := range o.entries {
+		if entry.ID == id {
+			o.entries = append(o.entries[:i], o.entries[i+1:]...)
+			return
+		}
+	}
+}
+
+func (o *Outbox) untilNext() time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if len(o.entries) == 0 {
+		return time.Hour
+	}
+
+	next := o.entries[0].NextAttempt
+	for _, entry := range o.entries[1:] {
+		if entry.NextAttempt.Before(next) {
+			next = entry.NextAttempt
+		}
+	}
+
+	wait := next.Sub(o.now())
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// backoff doubles the initial delay for every failed attempt, capped at the
+// configured maximum.
+func (o *Outbox) backoff(attempts int) time.Duration {
+	delay := o.policy.InitialBackoff
+	for i := 1; i < attempts && delay < o.policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if o.policy.MaxBackoff > 0 && delay > o.policy.MaxBackoff {
+		delay = o.policy.MaxBackoff
+	}
+	return delay
+}
+
+func (o *Outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (o *Outbox) flush() error {
+	if o.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(o.entries)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(o.path, data)
+}
+
+func (o *Outbox) flushLogged() {
+	if err := o.flush(); err != nil && o.logger != nil {
+		o.logger.Error("failed to persist outbox", zap.String("path", o.path), zap.Error(err))
+	}
+}
@@ -0,0 +1,127 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+)
+
+func testOutboxPolicy() config.Outbox {
+	return config.Outbox{MaxAttempts: 3, InitialBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond}
+}
+
+func TestOutboxPersistsEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telegram-outbox.json")
+
+	outbox, err := OpenOutbox(path, testOutboxPolicy(), nil)
+	if err != nil {
+		t.Fatalf("OpenOutbox() error = %v", err)
+	}
+	session := &Session{ChatID: 42, Blocks: []Block{CodeBlock{Content: "x"}}}
+	if err := outbox.Enqueue(session, errors.New("notion down")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	reopened, err := OpenOutbox(path, testOutboxPolicy(), nil)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	if reopened.Len() != 1 {
+		t.Fatalf("Len() = %d, want 1", reopened.Len())
+	}
+}
+
+func TestOutboxRetriesUntilSaved(t *testing.T) {
+	outbox, err := OpenOutbox("", testOutboxPolicy(), nil)
+	if err != nil {
+		t.Fatalf("OpenOutbox() error = %v", err)
+	}
+	if err := outbox.Enqueue(&Session{ChatID: 7, Blocks: []Block{CodeBlock{Content: "x"}}}, errors.New("boom")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	calls := 0
+	notified := make(chan string, 1)
+	save := func(ctx context.Context, session *Session) (SavedPage, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls < 2 {
+			return SavedPage{}, errors.New("still down")
+		}
+		if session.ChatID != 7 || len(session.Blocks) != 1 {
+			t.Errorf("unexpected session: %+v", session)
+		}
+		return SavedPage{ID: "page", URL: "https://www.notion.so/page", Title: "Notes", Blocks: 1}, nil
+	}
+	notify := func(chatID int64, message string) {
+		notified <- message
+	}
+
+	go outbox.Run(ctx, save, notify)
+
+	select {
+	case message := <-notified:
+		if message != `Saved 1 block to "Notes": https://www.notion.so/page (after 3 attempts)` {
+			t.Fatalf("unexpected notification %q", message)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for retry")
+	}
+	if outbox.Len() != 0 {
+		t.Fatalf("expected empty outbox, got %d", outbox.Len())
+	}
+}
+
+func TestOutboxGivesUpAfterMaxAttempts(t *testing.T) {
+	outbox, err := OpenOutbox("", testOutboxPolicy(), nil)
+	if err != nil {
+		t.Fatalf("OpenOutbox() error = %v", err)
+	}
+	if err := outbox.Enqueue(&Session{ChatID: 7}, errors.New("boom")); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	notified := make(chan string, 1)
+	go outbox.Run(ctx, func(context.Context, *Session) (SavedPage, error) {
+		return SavedPage{}, errors.New("permanent")
+	}, func(chatID int64, message string) {
+		notified <- message
+	})
+
+	select {
+	case message := <-notified:
+		if !strings.HasPrefix(message, "Failed to save") {
+			t.Fatalf("unexpected notification %q", message)
+		}
+	case <-ctx.Done():
+		t.Fatalf("timed out waiting for give-up")
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	outbox := &Outbox{policy: config.Outbox{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}}
+
+	if got := outbox.backoff(1); got != time.Second {
+		t.Fatalf("backoff(1) = %v", got)
+	}
+	if got := outbox.backoff(3); got != 4*time.Second {
+		t.Fatalf("backoff(3) = %v", got)
+	}
+	if got := outbox.backoff(10); got != 5*time.Second {
+		t.Fatalf("backoff(10) = %v", got)
+	}
+}
@@ -0,0 +1,96 @@
+package session
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"go.uber.org/zap"
+)
+
+// PageLog remembers the page each chat's most recent save went to, for /last
+// and /append last. When path is set it is written to disk on every change so
+// it survives restarts.
+type PageLog struct {
+	mu     sync.Mutex
+	path   string
+	last   map[int64]SavedPage
+	logger *zap.Logger
+}
+
+func OpenPageLog(path string, logger *zap.Logger) (*PageLog, error) {
+	l := &PageLog{path: path, last: make(map[int64]SavedPage), logger: logger}
+	if path == "" {
+		return l, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return l, nil
+		}
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &l.last); err != nil {
+			return nil, fmt.Errorf("decode page log: %w", err)
+		}
+	}
+	return l, nil
+}
+
+// openPageLog places the page log next to the session journal, or keeps it in
+// memory when session.store_dir is not configured.
+func openPageLog(cfg *config.Config, name string, logger *zap.Logger) (*PageLog, error) {
+	path := ""
+	if cfg.Session.StoreDir != "" {
+		path = filepath.Join(cfg.Session.StoreDir, name+"-pages.json")
+	}
+
+	pages, err := OpenPageLog(path, logger)
+	if err != nil {
+		return nil, fmt.Errorf("open page log: %w", err)
+	}
+	return pages, nil
+}
+
+// Last returns the page the chat's most recent save went to.
+func (l *PageLog) Last(chatID int64) (SavedPage, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	page, ok := l.last[chatID]
+	return page, ok
+}
+
+// Remember records page as the chat's most recent save.
+func (l *PageLog) Remember(chatID int64, page SavedPage) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.last[chatID] == page {
+		return
+	}
+	l.last[chatID] = page
+	if err := l.flush(); err != nil && l.logger != nil {
+		l.logger.Error("failed to persist page log", zap.String("path", l.path), zap.Error(err))
+	}
+}
+
+func (l *PageLog) flush() error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(l.last)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, data)
+}
@@ -0,0 +1,28 @@
+package session
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPageLogPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telegram-pages.json")
+
+	pages, err := OpenPageLog(path, nil)
+	if err != nil {
+		t.Fatalf("OpenPageLog() error = %v", err)
+	}
+	saved := SavedPage{ID: "page", URL: "https://www.notion.so/page", Title: "Notes", Blocks: 2}
+	pages.Remember(1, saved)
+
+	reopened, err := OpenPageLog(path, nil)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	if got, ok := reopened.Last(1); !ok || got != saved {
+		t.Fatalf("Last() = %+v, %v", got, ok)
+	}
+	if _, ok := reopened.Last(2); ok {
+		t.Fatal("expected no page for another chat")
+	}
+}
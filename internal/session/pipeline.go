@@ -0,0 +1,695 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jomei/notionapi"
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"github.com/nerdneilsfield/telenotion-bot/internal/github"
+	"github.com/nerdneilsfield/telenotion-bot/internal/notion"
+	"go.uber.org/zap"
+)
+
+// Platform adapts a chat service to the shared capture pipeline.
+type Platform interface {
+	// Origin is the value written to the Notion origin property.
+	Origin() string
+	// ResolveMedia returns a downloadable URL for a platform file ID or URL,
+	// or an empty string when there is nothing to fetch.
+	ResolveMedia(ctx context.Context, fileID string, fileURL string) (string, error)
+	// Notify sends a notice to the chat a session belongs to.
+	Notify(chatID int64, message string)
+	// ChatField identifies a chat in log entries.
+	ChatField(chatID int64) zap.Field
+}
+
+// Pipeline turns sessions into Notion pages for a single platform and
+// retries failed saves through the outbox.
+type Pipeline struct {
+	cfg      *config.Config
+	platform Platform
+	notion   *notion.Client
+	github   *github.Client
+	outbox   *Outbox
+	pages    *PageLog
+	logger   *zap.Logger
+
+	// journalMu serializes day page lookups so concurrent captures do not
+	// create the same page twice. journalPages caches the day pages by title.
+	journalMu    sync.Mutex
+	journalPages map[string]journalEntry
+}
+
+// journalEntry is a cached day page and the date it was looked up on.
+type journalEntry struct {
+	pageID string
+	day    string
+}
+
+// NewPipeline returns a pipeline for platform. Without pages, saved pages are
+// only remembered in memory.
+func NewPipeline(cfg *config.Config, platform Platform, notionClient *notion.Client, githubClient *github.Client, outbox *Outbox, pages *PageLog, logger *zap.Logger) *Pipeline {
+	if pages == nil {
+		pages = &PageLog{last: make(map[int64]SavedPage)}
+	}
+	return &Pipeline{
+		cfg:      cfg,
+		platform: platform,
+		notion:   notionClient,
+		github:   githubClient,
+		outbox:   outbox,
+		pages:    pages,
+		logger:   logger,
+	}
+}
+
+// RunOutbox retries queued saves until ctx is cancelled.
+func (p *Pipeline) RunOutbox(ctx context.Context) {
+	p.outbox.Run(ctx, p.Save, p.platform.Notify)
+}
+
+// Finish saves an ended session and returns the message to show the user.
+// Failed saves are handed to the outbox.
+func (p *Pipeline) Finish(ctx context.Context, session *Session) string {
+	saved, err := p.Save(ctx, session)
+	if err == nil {
+		if p.logger != nil {
+			p.logger.Info("session ended", p.platform.ChatField(session.ChatID), zap.String("session", session.Name))
+		}
+		return saved.message()
+	}
+	if errors.Is(err, ErrNoBlocks) {
+		return "Nothing to save. Session ended."
+	}
+	return p.queue(session, err)
+}
+
+// Journal appends blocks to the current day's page. When that fails the
+// blocks are queued for retry and the chat is told.
+func (p *Pipeline) Journal(ctx context.Context, chatID int64, blocks []Block) {
+	if len(blocks) == 0 {
+		return
+	}
+	loc, err := p.cfg.Title.Location()
+	if err != nil {
+		if p.logger != nil {
+			p.logger.Error("failed to load title timezone", zap.Error(err))
+		}
+		return
+	}
+
+	session := &Session{
+		ChatID:       chatID,
+		Name:         DefaultSessionName,
+		Blocks:       blocks,
+		UpdatedAt:    time.Now(),
+		JournalTitle: p.cfg.Title.FormatJournal(loc),
+	}
+	_, err = p.Save(ctx, session)
+	if err == nil || errors.Is(err, ErrNoBlocks) {
+		return
+	}
+	p.platform.Notify(chatID, p.queue(session, err))
+}
+
+// Instant saves the blocks of a single message as their own page and returns
+// the message to show the user, or an empty string when there was nothing to
+// save.
+func (p *Pipeline) Instant(ctx context.Context, chatID int64, blocks []Block) string {
+	if len(blocks) == 0 {
+		return ""
+	}
+
+	session := &Session{ChatID: chatID, Name: DefaultSessionName, Blocks: blocks, UpdatedAt: time.Now()}
+	saved, err := p.Save(ctx, session)
+	if err == nil {
+		return saved.message()
+	}
+	if errors.Is(err, ErrNoBlocks) {
+		return ""
+	}
+	return p.queue(session, err)
+}
+
+// Mirror saves mirrored channel posts, each as its own page or, when daily
+// is set, appended to the channel's page of the day. Failures are queued for
+// retry; nobody in the channel is told.
+func (p *Pipeline) Mirror(ctx context.Context, chatID int64, channel string, daily bool, blocks []Block) {
+	if len(blocks) == 0 {
+		return
+	}
+
+	session := &Session{ChatID: chatID, Name: channel, Blocks: blocks, UpdatedAt: time.Now()}
+	if daily {
+		loc, err := p.cfg.Title.Location()
+		if err != nil {
+			if p.logger != nil {
+				p.logger.Error("failed to load title timezone", zap.Error(err))
+			}
+			return
+		}
+		session.JournalTitle = p.cfg.Title.FormatJournal(loc)
+		if channel != "" {
+			session.JournalTitle += " - " + channel
+		}
+	}
+	_, err := p.Save(ctx, session)
+	if err == nil || errors.Is(err, ErrNoBlocks) {
+		return
+	}
+	p.queue(session, err)
+}
+
+// queue hands a failed save to the outbox and returns the message to show the
+// user.
+func (p *Pipeline) queue(session *Session, err error) string {
+	if p.logger != nil {
+		p.logger.Error("failed to save to notion", p.platform.ChatField(session.ChatID), zap.Error(err))
+	}
+	if qerr := p.outbox.Enqueue(session, err); qerr != nil {
+		if p.logger != nil {
+			p.logger.Error("failed to queue session for retry", p.platform.ChatField(session.ChatID), zap.Error(qerr))
+		}
+		return "Failed to save. Check logs for details."
+	}
+	return "Failed to save. Queued for retry; you will be notified when it is saved."
+}
+
+// Save creates a Notion page holding the session's blocks, or appends them to
+// session.AppendTo or the session's journal page when set, and returns the
+// page the blocks went to.
+func (p *Pipeline) Save(ctx context.Context, session *Session) (SavedPage, error) {
+	loc, err := p.cfg.Title.Location()
+	if err != nil {
+		return SavedPage{}, err
+	}
+
+	blocks, err := p.BuildBlocks(ctx, session)
+	if err != nil {
+		return SavedPage{}, err
+	}
+	if len(blocks) == 0 {
+		return SavedPage{}, ErrNoBlocks
+	}
+
+	origin := p.platform.Origin()
+	pageID := session.AppendTo
+	if pageID == "" && session.JournalTitle != "" {
+		pageID, err = p.journalPage(ctx, session.JournalTitle, origin)
+		if err != nil {
+			return SavedPage{}, err
+		}
+	}
+	if pageID != "" {
+		if p.logger != nil {
+			p.logger.Info("appending to notion page", zap.String("page_id", pageID))
+		}
+		if session.Written > 0 {
+			blocks = blocks[min(session.Written, len(blocks)):]
+		}
+		if err := p.notion.AppendBlocks(ctx, pageID, blocks); err != nil {
+			resumeAfter(session, err, "")
+			return SavedPage{}, err
+		}
+		saved := SavedPage{ID: pageID, URL: notion.PageURL(pageID), Title: session.JournalTitle, Blocks: len(session.Blocks), Appended: true}
+		if session.CreatedTitle != "" {
+			saved.Title, saved.Appended = session.CreatedTitle, false
+		}
+		p.rememberPage(session.ChatID, saved)
+		return saved, nil
+	}
+
+	title := p.cfg.Title.FormatTime(loc)
+	if session.Name != "" && session.Name != DefaultSessionName {
+		title += " - " + session.Name
+	}
+	if p.logger != nil {
+		p.logger.Info("creating notion page", zap.String("origin_property", p.cfg.Notion.OriginProperty), zap.String("origin", origin))
+	}
+	page := notion.Page{
+		TitleProperty:  p.cfg.Notion.TitleProperty,
+		Title:          title,
+		OriginProperty: p.cfg.Notion.OriginProperty,
+		Origin:         origin,
+		SourceProperty: p.cfg.Notion.SourceProperty,
+	}
+	if source, ok := firstSource(session.Blocks); ok && page.SourceProperty != "" {
+		page.Source = p.sourceRichText(source, false)
+	}
+	created, err := p.notion.CreatePage(ctx, p.cfg.Notion.DatabaseID, page, blocks)
+	if err != nil {
+		resumeAfter(session, err, title)
+		return SavedPage{}, err
+	}
+	saved := SavedPage{ID: created.ID, URL: created.URL, Title: title, Blocks: len(session.Blocks)}
+	p.rememberPage(session.ChatID, saved)
+	return saved, nil
+}
+
+// resumeAfter records how far a write that failed partway got, so a retry
+// appends the remaining blocks to the same page instead of writing a second
+// copy. created is the title of the page when the failed write created it.
+func resumeAfter(session *Session, err error, created string) {
+	var partial *notion.PartialWriteError
+	if !errors.As(err, &partial) {
+		return
+	}
+	session.AppendTo = partial.PageID
+	session.Written += partial.Written
+	if created != "" {
+		session.CreatedTitle = created
+	}
+}
+
+// journalPage finds or creates the journal page titled title, remembering the
+// current day's pages between captures. The journal and every daily channel
+// have a page of their own, so they are cached side by side; pages looked up
+// on earlier days are dropped.
+func (p *Pipeline) journalPage(ctx context.Context, title string, origin string) (string, error) {
+	p.journalMu.Lock()
+	defer p.journalMu.Unlock()
+
+	today := time.Now().In(p.location()).Format(time.DateOnly)
+	for cached, entry := range p.journalPages {
+		if entry.day != today {
+			delete(p.journalPages, cached)
+		}
+	}
+	if entry, ok := p.journalPages[title]; ok {
+		return entry.pageID, nil
+	}
+
+	pageID, err := p.notion.FindOrCreatePage(ctx, p.cfg.Notion.DatabaseID, notion.Page{
+		TitleProperty:  p.cfg.Notion.TitleProperty,
+		Title:          title,
+		OriginProperty: p.cfg.Notion.OriginProperty,
+		Origin:         origin,
+	})
+	if err != nil {
+		return "", err
+	}
+	if p.journalPages == nil {
+		p.journalPages = make(map[string]journalEntry)
+	}
+	p.journalPages[title] = journalEntry{pageID: pageID, day: today}
+	return pageID, nil
+}
+
+// SavedPage describes where a save went.
+type SavedPage struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// Title is the page title, when known; pages named by /append are not
+	// looked up.
+	Title  string `json:"title,omitempty"`
+	Blocks int    `json:"blocks"`
+	// Appended is set when the blocks went to an existing page.
+	Appended bool `json:"appended,omitempty"`
+}
+
+// message tells the user about the save, link included.
+func (s SavedPage) message() string {
+	target := "Notion"
+	if s.Title != "" {
+		target = fmt.Sprintf("%q", s.Title)
+	}
+	if s.Appended {
+		return fmt.Sprintf("Appended %s to %s: %s", pluralBlocks(s.Blocks), target, s.URL)
+	}
+	return fmt.Sprintf("Saved %s to %s: %s", pluralBlocks(s.Blocks), target, s.URL)
+}
+
+// LastPage returns the page the chat's most recent save went to.
+func (p *Pipeline) LastPage(chatID int64) (SavedPage, bool) {
+	return p.pages.Last(chatID)
+}
+
+func (p *Pipeline) rememberPage(chatID int64, page SavedPage) {
+	if page.ID == "" {
+		return
+	}
+	p.pages.Remember(chatID, page)
+}
+
+// BuildBlocks converts buffered blocks into Notion blocks, uploading images
+// to the media host on the way.
+func (p *Pipeline) BuildBlocks(ctx context.Context, session *Session) ([]notionapi.Block, error) {
+	blocks := make([]notionapi.Block, 0, len(session.Blocks))
+	rendered := make(map[string]bool)
+	attributed := make(map[string]bool)
+
+	for i, block := range session.Blocks {
+		if album := albumOf(block); album != "" {
+			if rendered[album] {
+				continue
+			}
+			rendered[album] = true
+			gallery, err := p.albumBlocks(ctx, session.ChatID, albumMembers(session.Blocks[i:], album))
+			if err != nil {
+				return nil, err
+			}
+			blocks = append(blocks, gallery...)
+			continue
+		}
+
+		switch b := block.(type) {
+		case SourceBlock:
+			if b.Album != "" {
+				key := b.Album
+				if b.Posted {
+					key = "posted:" + key
+				}
+				if attributed[key] {
+					continue
+				}
+				attributed[key] = true
+			}
+			icon := forwardedEmoji
+			if b.Posted {
+				icon = postedEmoji
+			}
+			blocks = append(blocks, &notionapi.CalloutBlock{
+				BasicBlock: notionapi.BasicBlock{Object: "block", Type: "callout"},
+				Callout: notionapi.Callout{
+					RichText: p.sourceRichText(b, true),
+					Icon:     &notionapi.Icon{Type: "emoji", Emoji: &icon},
+					Color:    notionapi.ColorGrayBackground.String(),
+				},
+			})
+		case StickerBlock:
+			sticker, err := p.stickerBlock(ctx, b)
+			if err != nil {
+				return nil, err
+			}
+			if sticker != nil {
+				blocks = append(blocks, sticker)
+			}
+		case LocationBlock:
+			blocks = append(blocks, locationBlock(b))
+		case ContactBlock:
+			blocks = append(blocks, contactBlock(b))
+		case PollBlock:
+			blocks = append(blocks, pollBlocks(b)...)
+		case TextBlock:
+			richTexts := splitRichTextEntries(b.RichText)
+			for _, chunk := range chunkRichText(richTexts, notionRichTextBlockLimit) {
+				blocks = append(blocks, &notionapi.ParagraphBlock{
+					BasicBlock: notionapi.BasicBlock{Object: "block", Type: "paragraph"},
+					Paragraph:  notionapi.Paragraph{RichText: chunk},
+				})
+			}
+		case QuoteBlock:
+			richTexts := splitRichTextEntries(b.RichText)
+			for _, chunk := range chunkRichText(richTexts, notionRichTextBlockLimit) {
+				blocks = append(blocks, &notionapi.QuoteBlock{
+					BasicBlock: notionapi.BasicBlock{Object: "block", Type: "quote"},
+					Quote:      notionapi.Quote{RichText: chunk},
+				})
+			}
+		case CodeBlock:
+			language := b.Language
+			if language == "" {
+				language = "plain text"
+			}
+			richTexts := splitRichTextEntries([]notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: b.Content}}})
+			for _, chunk := range chunkRichText(richTexts, notionRichTextBlockLimit) {
+				blocks = append(blocks, &notionapi.CodeBlock{
+					BasicBlock: notionapi.BasicBlock{Object: "block", Type: "code"},
+					Code: notionapi.Code{
+						RichText: chunk,
+						Language: language,
+					},
+				})
+			}
+		case ImageBlock:
+			image, err := p.imageBlock(ctx, session.ChatID, b)
+			if err != nil {
+				return nil, err
+			}
+			if image != nil {
+				blocks = append(blocks, image)
+			}
+		case FileBlock:
+			file, err := p.fileBlock(ctx, session.ChatID, b)
+			if err != nil {
+				return nil, err
+			}
+			if file != nil {
+				blocks = append(blocks, file)
+			}
+		}
+	}
+
+	return blocks, nil
+}
+
+func (p *Pipeline) imageBlock(ctx context.Context, chatID int64, b ImageBlock) (notionapi.Block, error) {
+	fileURL, err := p.platform.ResolveMedia(ctx, b.FileID, b.FileURL)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			p.platform.Notify(chatID, imageTooLargeMessage)
+			return placeholderBlock(imageTooLargeMessage), nil
+		}
+		return nil, err
+	}
+	if fileURL == "" {
+		return nil, nil
+	}
+
+	data, extension, err := downloadImage(ctx, fileURL)
+	if err != nil {
+		if errors.Is(err, ErrImageTooLarge) {
+			p.platform.Notify(chatID, imageTooLargeMessage)
+			return placeholderBlock(imageTooLargeMessage), nil
+		}
+		if errors.Is(err, ErrUnsupportedImageType) {
+			p.platform.Notify(chatID, imageUnsupportedTypeMessage)
+			return placeholderBlock(imageUnsupportedTypeMessage), nil
+		}
+		return nil, err
+	}
+
+	rawURL, err := p.github.UploadImage(ctx, data, extension)
+	if err != nil {
+		return nil, err
+	}
+
+	image := &notionapi.ImageBlock{
+		BasicBlock: notionapi.BasicBlock{Object: "block", Type: "image"},
+		Image:      notionapi.Image{External: &notionapi.FileObject{URL: rawURL}},
+	}
+	if caption := imageCaption(b); len(caption) > 0 {
+		image.Image.Caption = caption
+	}
+	return image, nil
+}
+
+func (p *Pipeline) fileBlock(ctx context.Context, chatID int64, b FileBlock) (notionapi.Block, error) {
+	if b.Size > maxFileSizeBytes {
+		p.platform.Notify(chatID, fileTooLargeMessage)
+		return placeholderBlock(fileTooLargeMessage), nil
+	}
+	if b.MimeType != "" && !fileTypeAllowed(b.MimeType) {
+		p.platform.Notify(chatID, fileUnsupportedTypeMessage)
+		return placeholderBlock(fileUnsupportedTypeMessage), nil
+	}
+
+	fileURL, err := p.platform.ResolveMedia(ctx, b.FileID, b.FileURL)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			p.platform.Notify(chatID, fileTooLargeMessage)
+			return placeholderBlock(fileTooLargeMessage), nil
+		}
+		return nil, err
+	}
+	if fileURL == "" {
+		return nil, nil
+	}
+
+	data, contentType, err := downloadFile(ctx, fileURL, b.MimeType)
+	if err != nil {
+		if errors.Is(err, ErrFileTooLarge) {
+			p.platform.Notify(chatID, fileTooLargeMessage)
+			return placeholderBlock(fileTooLargeMessage), nil
+		}
+		if errors.Is(err, ErrUnsupportedFileType) {
+			p.platform.Notify(chatID, fileUnsupportedTypeMessage)
+			return placeholderBlock(fileUnsupportedTypeMessage), nil
+		}
+		return nil, err
+	}
+
+	rawURL, err := p.github.UploadFile(ctx, data, fileExtension(b.Filename, contentType))
+	if err != nil {
+		return nil, err
+	}
+	return notionFileBlock(b, contentType, rawURL), nil
+}
+
+var (
+	forwardedEmoji = notionapi.Emoji("↪️")
+	postedEmoji    = notionapi.Emoji("📣")
+)
+
+// sourceRichText renders the attribution of a forwarded message or mirrored
+// post: the name, linked when public, and the original date. withLabel adds
+// the "Forwarded from" or "Posted in" lead-in used in the page body.
+func (p *Pipeline) sourceRichText(b SourceBlock, withLabel bool) []notionapi.RichText {
+	richTexts := make([]notionapi.RichText, 0, 3)
+	if withLabel {
+		label := "Forwarded from "
+		if b.Posted {
+			label = "Posted in "
+		}
+		richTexts = append(richTexts, notionapi.RichText{Type: "text", Text: &notionapi.Text{Content: label}})
+	}
+
+	name := notionapi.RichText{Type: "text", Text: &notionapi.Text{Content: b.Name}}
+	if b.URL != "" {
+		name.Text.Link = &notionapi.Link{Url: b.URL}
+	}
+	if withLabel {
+		name.Annotations = &notionapi.Annotations{Bold: true}
+	}
+	richTexts = append(richTexts, name)
+
+	if !b.Date.IsZero() {
+		richTexts = append(richTexts, notionapi.RichText{Type: "text", Text: &notionapi.Text{Content: " · " + b.Date.In(p.location()).Format(sourceDateFormat)}})
+	}
+	return richTexts
+}
+
+const sourceDateFormat = "2006-01-02 15:04"
+
+// location is the configured title timezone, falling back to UTC.
+func (p *Pipeline) location() *time.Location {
+	if p.cfg == nil {
+		return time.UTC
+	}
+	loc, err := p.cfg.Title.Location()
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// firstSource returns the attribution of the first forwarded message.
+func firstSource(blocks []Block) (SourceBlock, bool) {
+	for _, block := range blocks {
+		if source, ok := block.(SourceBlock); ok {
+			return source, true
+		}
+	}
+	return SourceBlock{}, false
+}
+
+// stickerBlock uploads the sticker, or the thumbnail of an animated one. Video
+// stickers cannot be told apart up front, so a sticker that turns out not to
+// be a still image falls back to its thumbnail, and then to its emoji.
+func (p *Pipeline) stickerBlock(ctx context.Context, b StickerBlock) (notionapi.Block, error) {
+	candidates := make([]string, 0, 2)
+	if !b.Animated && b.FileID != "" {
+		candidates = append(candidates, b.FileID)
+	}
+	if b.ThumbnailID != "" {
+		candidates = append(candidates, b.ThumbnailID)
+	}
+
+	for _, fileID := range candidates {
+		fileURL, err := p.platform.ResolveMedia(ctx, fileID, "")
+		if err != nil {
+			return nil, err
+		}
+		if fileURL == "" {
+			continue
+		}
+
+		data, extension, err := downloadImage(ctx, fileURL)
+		if errors.Is(err, ErrUnsupportedImageType) || errors.Is(err, ErrImageTooLarge) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		rawURL, err := p.github.UploadImage(ctx, data, extension)
+		if err != nil {
+			return nil, err
+		}
+		return &notionapi.ImageBlock{
+			BasicBlock: notionapi.BasicBlock{Object: "block", Type: "image"},
+			Image:      notionapi.Image{External: &notionapi.FileObject{URL: rawURL}},
+		}, nil
+	}
+
+	if b.Emoji == "" {
+		return nil, nil
+	}
+	return &notionapi.ParagraphBlock{
+		BasicBlock: notionapi.BasicBlock{Object: "block", Type: "paragraph"},
+		Paragraph:  notionapi.Paragraph{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: b.Emoji}}}},
+	}, nil
+}
+
+// notionFileBlock picks the Notion block that can play or preview the file,
+// falling back to a plain file attachment.
+func notionFileBlock(b FileBlock, contentType string, url string) notionapi.Block {
+	external := &notionapi.FileObject{URL: url}
+	caption := fileCaption(b)
+
+	switch {
+	case contentType == "application/pdf":
+		return &notionapi.PdfBlock{
+			BasicBlock: notionapi.BasicBlock{Object: "block", Type: "pdf"},
+			Pdf:        notionapi.Pdf{Type: notionapi.FileTypeExternal, External: external, Caption: caption},
+		}
+	case b.Media == MediaVideo || b.Media == MediaAnimation || strings.HasPrefix(contentType, "video/"):
+		return &notionapi.VideoBlock{
+			BasicBlock: notionapi.BasicBlock{Object: "block", Type: "video"},
+			Video:      notionapi.Video{Type: notionapi.FileTypeExternal, External: external, Caption: caption},
+		}
+	case b.Media == MediaAudio || b.Media == MediaVoice || strings.HasPrefix(contentType, "audio/"):
+		return &notionapi.AudioBlock{
+			BasicBlock: notionapi.BasicBlock{Object: "block", Type: "audio"},
+			Audio:      notionapi.Audio{Type: notionapi.FileTypeExternal, External: external, Caption: caption},
+		}
+	default:
+		return &notionapi.FileBlock{
+			BasicBlock: notionapi.BasicBlock{Object: "block", Type: "file"},
+			File:       notionapi.BlockFile{Type: notionapi.FileTypeExternal, External: external, Caption: caption},
+		}
+	}
+}
+
+// fileCaption uses the message caption, or the file name when there is none.
+func fileCaption(b FileBlock) []notionapi.RichText {
+	if len(b.CaptionRichText) == 0 && b.Caption == "" {
+		if b.Filename == "" {
+			return nil
+		}
+		return []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: b.Filename}}}
+	}
+	return imageCaption(ImageBlock{Caption: b.Caption, CaptionRichText: b.CaptionRichText})
+}
+
+// imageCaption prefers the formatted caption and keeps it within the rich
+// text limits of a single block.
+func imageCaption(b ImageBlock) []notionapi.RichText {
+	richTexts := b.CaptionRichText
+	if len(richTexts) == 0 {
+		if b.Caption == "" {
+			return nil
+		}
+		richTexts = []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: b.Caption}}}
+	}
+	chunks := chunkRichText(splitRichTextEntries(richTexts), notionRichTextBlockLimit)
+	if len(chunks) == 0 {
+		return nil
+	}
+	return chunks[0]
+}
@@ -0,0 +1,408 @@
+package session
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jomei/notionapi"
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"github.com/nerdneilsfield/telenotion-bot/internal/notion"
+	"go.uber.org/zap"
+)
+
+type fakePlatform struct {
+	mediaURL   string
+	resolveErr error
+	notices    []string
+}
+
+func (f *fakePlatform) Origin() string {
+	return "Fake"
+}
+
+func (f *fakePlatform) ResolveMedia(ctx context.Context, fileID string, fileURL string) (string, error) {
+	if fileURL != "" {
+		return fileURL, nil
+	}
+	return f.mediaURL, f.resolveErr
+}
+
+func (f *fakePlatform) Notify(chatID int64, message string) {
+	f.notices = append(f.notices, message)
+}
+
+func (f *fakePlatform) ChatField(chatID int64) zap.Field {
+	return zap.Int64("chat_id", chatID)
+}
+
+type notionTransport func(*http.Request) (*http.Response, error)
+
+func (f notionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// fakeNotion records the pages created, database queries made and blocks
+// appended through it. Queries find the page existing when it is set. While
+// failAppend is set, appends to a page that already received one batch fail.
+type fakeNotion struct {
+	mu         sync.Mutex
+	pages      int
+	titles     []string
+	queries    []string
+	existing   string
+	appended   map[string][]int
+	failAppend bool
+}
+
+func newFakeNotion() (*fakeNotion, *notion.Client) {
+	f := &fakeNotion{appended: make(map[string][]int)}
+	transport := notionTransport(func(req *http.Request) (*http.Response, error) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		status, body := http.StatusOK, `{"object":"list","results":[]}`
+		switch {
+		case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/pages"):
+			var request struct {
+				Properties map[string]struct {
+					Title []struct {
+						Text struct {
+							Content string `json:"content"`
+						} `json:"text"`
+					} `json:"title"`
+				} `json:"properties"`
+			}
+			data, _ := io.ReadAll(req.Body)
+			_ = json.Unmarshal(data, &request)
+			title := ""
+			if property := request.Properties["Name"]; len(property.Title) > 0 {
+				title = property.Title[0].Text.Content
+			}
+			f.pages++
+			f.titles = append(f.titles, title)
+			id := fmt.Sprintf("page-%d", f.pages)
+			body = fmt.Sprintf(`{"object":"page","id":%q,"url":"https://www.notion.so/%s"}`, id, id)
+		case req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/query"):
+			data, _ := io.ReadAll(req.Body)
+			f.queries = append(f.queries, string(data))
+			if f.existing != "" {
+				body = fmt.Sprintf(`{"object":"list","results":[{"object":"page","id":%q}]}`, f.existing)
+			}
+		case req.Method == http.MethodPatch && strings.HasSuffix(req.URL.Path, "/children"):
+			pageID := strings.TrimSuffix(strings.TrimPrefix(req.URL.Path, "/v1/blocks/"), "/children")
+			if f.failAppend && len(f.appended[pageID]) > 0 {
+				status, body = http.StatusInternalServerError, `{"object":"error","status":500,"code":"internal_server_error","message":"boom"}`
+				break
+			}
+			var request struct {
+				Children []any `json:"children"`
+			}
+			data, _ := io.ReadAll(req.Body)
+			_ = json.Unmarshal(data, &request)
+			f.appended[pageID] = append(f.appended[pageID], len(request.Children))
+		}
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": []string{"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Request:    req,
+		}, nil
+	})
+	return f, notion.NewClient("test-token", notionapi.WithHTTPClient(&http.Client{Transport: transport}))
+}
+
+func testPipelineConfig() *config.Config {
+	return &config.Config{
+		Notion: config.Notion{DatabaseID: "database", TitleProperty: "Name"},
+		Title:  config.Title{Timezone: "UTC", Format: "2006-01-02 15:04", JournalFormat: "2006-01-02"},
+	}
+}
+
+func textBlocks(n int) []Block {
+	blocks := make([]Block, 0, n)
+	for i := 0; i < n; i++ {
+		blocks = append(blocks, TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: fmt.Sprintf("line %d", i)}}}})
+	}
+	return blocks
+}
+
+func TestPipelineRetryResumesPartialPage(t *testing.T) {
+	fake, client := newFakeNotion()
+	fake.failAppend = true
+	outbox, err := OpenOutbox("", config.Outbox{MaxAttempts: 3}, nil)
+	if err != nil {
+		t.Fatalf("OpenOutbox() error = %v", err)
+	}
+	platform := &fakePlatform{}
+	pipeline := NewPipeline(testPipelineConfig(), platform, client, nil, outbox, nil, nil)
+
+	// 250 blocks: 100 with the page, then appends of 100 and 50; the second
+	// append fails.
+	response := pipeline.Finish(context.Background(), &Session{ChatID: 1, Name: DefaultSessionName, Blocks: textBlocks(250)})
+	if !strings.HasPrefix(response, "Failed to save. Queued for retry") {
+		t.Fatalf("unexpected response %q", response)
+	}
+
+	fake.mu.Lock()
+	fake.failAppend = false
+	fake.mu.Unlock()
+	outbox.processDue(context.Background(), pipeline.Save, platform.Notify)
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.pages != 1 {
+		t.Fatalf("expected the retry to reuse the page, %d pages created", fake.pages)
+	}
+	if got := fake.appended["page-1"]; len(got) != 2 || got[0] != 100 || got[1] != 50 {
+		t.Fatalf("expected the remaining 50 blocks to be appended once, got batches %v", got)
+	}
+	if outbox.Len() != 0 {
+		t.Fatalf("expected the outbox to be empty, got %d entries", outbox.Len())
+	}
+	if len(platform.notices) != 1 || !strings.HasPrefix(platform.notices[0], `Saved 250 blocks to "`) ||
+		!strings.HasSuffix(platform.notices[0], `": https://www.notion.so/page1 (after 2 attempts)`) {
+		t.Fatalf("expected the retry to report the created page, got %q", platform.notices)
+	}
+}
+
+func TestPipelineInstantCreatesPagePerMessage(t *testing.T) {
+	fake, client := newFakeNotion()
+	pipeline := NewPipeline(testPipelineConfig(), &fakePlatform{}, client, nil, nil, nil, nil)
+	ctx := context.Background()
+
+	for i := 1; i <= 2; i++ {
+		response := pipeline.Instant(ctx, 1, textBlocks(1))
+		link := fmt.Sprintf("https://www.notion.so/page-%d", i)
+		if !strings.HasPrefix(response, "Saved 1 block to ") || !strings.HasSuffix(response, ": "+link) {
+			t.Fatalf("message %d: unexpected response %q", i, response)
+		}
+	}
+	if response := pipeline.Instant(ctx, 1, nil); response != "" {
+		t.Fatalf("expected no response without blocks, got %q", response)
+	}
+
+	fake.mu.Lock()
+	pages := fake.pages
+	fake.mu.Unlock()
+	if pages != 2 {
+		t.Fatalf("expected a page per message, got %d pages", pages)
+	}
+	if last, ok := pipeline.LastPage(1); !ok || last.ID != "page-2" {
+		t.Fatalf("expected the last page to be page-2, got %+v", last)
+	}
+}
+
+func TestPipelineJournalAppendsToExistingDayPage(t *testing.T) {
+	fake, client := newFakeNotion()
+	fake.existing = "existing"
+	pipeline := NewPipeline(testPipelineConfig(), &fakePlatform{}, client, nil, nil, nil, nil)
+
+	pipeline.Journal(context.Background(), 1, textBlocks(1))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.pages != 0 {
+		t.Fatalf("expected no page to be created, got %d", fake.pages)
+	}
+	if got := fake.appended["existing"]; len(got) != 1 || got[0] != 1 {
+		t.Fatalf("expected the block to go to the existing page, got batches %v", fake.appended)
+	}
+	if len(fake.queries) != 1 {
+		t.Fatalf("expected one query, got %d", len(fake.queries))
+	}
+	var query struct {
+		Filter struct {
+			Property string `json:"property"`
+			RichText struct {
+				Equals string `json:"equals"`
+			} `json:"rich_text"`
+		} `json:"filter"`
+	}
+	if err := json.Unmarshal([]byte(fake.queries[0]), &query); err != nil {
+		t.Fatalf("decode query: %v", err)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if query.Filter.Property != "Name" || query.Filter.RichText.Equals != day {
+		t.Fatalf("expected a title filter on Name for %q, got %s", day, fake.queries[0])
+	}
+}
+
+func TestPipelineJournalCreatesAndReusesDayPage(t *testing.T) {
+	fake, client := newFakeNotion()
+	pipeline := NewPipeline(testPipelineConfig(), &fakePlatform{}, client, nil, nil, nil, nil)
+	ctx := context.Background()
+
+	pipeline.Journal(ctx, 1, textBlocks(1))
+	pipeline.Journal(ctx, 2, textBlocks(2))
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.pages != 1 || fake.titles[0] != time.Now().UTC().Format("2006-01-02") {
+		t.Fatalf("expected one page titled with the date, got %q", fake.titles)
+	}
+	if len(fake.queries) != 1 {
+		t.Fatalf("expected the second capture to reuse the cached page, got %d queries", len(fake.queries))
+	}
+	if got := fake.appended["page-1"]; len(got) != 2 || got[0] != 1 || got[1] != 2 {
+		t.Fatalf("expected both captures appended to the day page, got batches %v", got)
+	}
+}
+
+func TestPipelineMirrorCachesDailyPagePerChannel(t *testing.T) {
+	fake, client := newFakeNotion()
+	pipeline := NewPipeline(testPipelineConfig(), &fakePlatform{}, client, nil, nil, nil, nil)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		pipeline.Mirror(ctx, -100, "alpha", true, textBlocks(1))
+		pipeline.Mirror(ctx, -200, "beta", true, textBlocks(1))
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.queries) != 2 || fake.pages != 2 {
+		t.Fatalf("expected one query and one page per channel, got %d queries and %d pages", len(fake.queries), fake.pages)
+	}
+	day := time.Now().UTC().Format("2006-01-02")
+	if fake.titles[0] != day+" - alpha" || fake.titles[1] != day+" - beta" {
+		t.Fatalf("unexpected page titles %q", fake.titles)
+	}
+	for _, page := range []string{"page-1", "page-2"} {
+		if got := fake.appended[page]; len(got) != 2 {
+			t.Fatalf("expected two posts appended to %s, got batches %v", page, got)
+		}
+	}
+}
+
+func TestPipelineBuildBlocks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("not an image"))
+	}))
+	defer server.Close()
+
+	platform := &fakePlatform{}
+	pipeline := NewPipeline(nil, platform, nil, nil, nil, nil, nil)
+
+	session := &Session{ChatID: 1, Blocks: []Block{
+		TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "hello"}}}},
+		CodeBlock{Content: "x := 1"},
+		ImageBlock{},
+		ImageBlock{FileURL: server.URL},
+	}}
+
+	blocks, err := pipeline.BuildBlocks(context.Background(), session)
+	if err != nil {
+		t.Fatalf("BuildBlocks() error = %v", err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("expected 3 blocks, got %d", len(blocks))
+	}
+	if blocks[0].GetType() != "paragraph" {
+		t.Fatalf("blocks[0] type = %q", blocks[0].GetType())
+	}
+	code, ok := blocks[1].(*notionapi.CodeBlock)
+	if !ok || code.Code.Language != "plain text" {
+		t.Fatalf("unexpected code block: %#v", blocks[1])
+	}
+	if blocks[2].GetType() != "paragraph" {
+		t.Fatalf("expected placeholder paragraph for unsupported image, got %q", blocks[2].GetType())
+	}
+	if len(platform.notices) != 1 || platform.notices[0] != imageUnsupportedTypeMessage {
+		t.Fatalf("unexpected notices: %v", platform.notices)
+	}
+}
+
+func TestNotionFileBlock(t *testing.T) {
+	cases := []struct {
+		block       FileBlock
+		contentType string
+		want        notionapi.BlockType
+	}{
+		{FileBlock{Media: MediaDocument}, "application/pdf", notionapi.BlockTypePdf},
+		{FileBlock{Media: MediaVoice}, "audio/ogg", notionapi.BlockType("audio")},
+		{FileBlock{Media: MediaAnimation}, "video/mp4", notionapi.BlockTypeVideo},
+		{FileBlock{Media: MediaDocument}, "video/webm", notionapi.BlockTypeVideo},
+		{FileBlock{Media: MediaDocument}, "application/zip", notionapi.BlockTypeFile},
+	}
+	for _, tc := range cases {
+		block := notionFileBlock(tc.block, tc.contentType, "https://example.com/file")
+		if block.GetType() != tc.want {
+			t.Errorf("notionFileBlock(%q, %q) type = %q, want %q", tc.block.Media, tc.contentType, block.GetType(), tc.want)
+		}
+	}
+}
+
+func TestPipelineBuildBlocksSource(t *testing.T) {
+	pipeline := NewPipeline(nil, &fakePlatform{}, nil, nil, nil, nil, nil)
+	date := time.Date(2024, 5, 1, 8, 30, 0, 0, time.UTC)
+	session := &Session{ChatID: 1, Blocks: []Block{
+		SourceBlock{Name: "News", URL: "https://t.me/news/1", Date: date, Album: "a"},
+		SourceBlock{Name: "News", URL: "https://t.me/news/2", Date: date, Album: "a"},
+	}}
+
+	blocks, err := pipeline.BuildBlocks(context.Background(), session)
+	if err != nil {
+		t.Fatalf("BuildBlocks() error = %v", err)
+	}
+	if len(blocks) != 1 {
+		t.Fatalf("expected one attribution per album, got %d blocks", len(blocks))
+	}
+	callout, ok := blocks[0].(*notionapi.CalloutBlock)
+	if !ok {
+		t.Fatalf("expected callout, got %#v", blocks[0])
+	}
+	richText := callout.Callout.RichText
+	if len(richText) != 3 || richText[1].Text.Link == nil || richText[1].Text.Link.Url != "https://t.me/news/1" {
+		t.Fatalf("unexpected attribution: %#v", richText)
+	}
+	if richText[2].Text.Content != " · 2024-05-01 08:30" {
+		t.Fatalf("unexpected date %q", richText[2].Text.Content)
+	}
+}
+
+func TestPipelineStickerFallsBackToEmoji(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte{0x1a, 0x45, 0xdf, 0xa3})
+	}))
+	defer server.Close()
+
+	platform := &fakePlatform{mediaURL: server.URL}
+	pipeline := NewPipeline(nil, platform, nil, nil, nil, nil, nil)
+
+	block, err := pipeline.stickerBlock(context.Background(), StickerBlock{FileID: "webm", ThumbnailID: "thumb", Emoji: "🙂"})
+	if err != nil {
+		t.Fatalf("stickerBlock() error = %v", err)
+	}
+	paragraph, ok := block.(*notionapi.ParagraphBlock)
+	if !ok || plainText(paragraph.Paragraph.RichText) != "🙂" {
+		t.Fatalf("expected emoji paragraph, got %#v", block)
+	}
+	if len(platform.notices) != 0 {
+		t.Fatalf("expected no notices, got %v", platform.notices)
+	}
+}
+
+func TestPipelineSkipsFilesTheBotAPIRefuses(t *testing.T) {
+	platform := &fakePlatform{resolveErr: fmt.Errorf("%w: file is too big", ErrFileTooLarge)}
+	pipeline := NewPipeline(nil, platform, nil, nil, nil, nil, nil)
+
+	session := &Session{ChatID: 1, Blocks: []Block{FileBlock{FileID: "big", Media: MediaDocument}}}
+	blocks, err := pipeline.BuildBlocks(context.Background(), session)
+	if err != nil {
+		t.Fatalf("BuildBlocks() error = %v", err)
+	}
+	if len(blocks) != 1 || blocks[0].GetType() != "paragraph" {
+		t.Fatalf("expected a placeholder, got %#v", blocks)
+	}
+	if len(platform.notices) != 1 || platform.notices[0] != fileTooLargeMessage {
+		t.Fatalf("expected a too large notice, got %q", platform.notices)
+	}
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/nerdneilsfield/telenotion-bot/internal/tgclient"
+	"go.uber.org/zap"
+)
+
+const (
+	pollTimeoutSeconds = 60
+	pollMinBackoff     = time.Second
+	pollMaxBackoff     = time.Minute
+)
+
+// poll long-polls for updates until ctx is cancelled. Failed polls are retried
+// with jittered exponential backoff, honouring Telegram's retry_after.
+func (r *Runner) poll(ctx context.Context) error {
+	if err := r.telegram.DeleteWebhook(); err != nil && r.logger != nil {
+		r.logger.Warn("failed to remove telegram webhook", zap.Error(err))
+	}
+
+	offset := 0
+	failures := 0
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		updates, err := r.telegram.GetUpdates(offset, pollTimeoutSeconds)
+		if err != nil {
+			delay, limited := retryAfter(err)
+			if !limited {
+				delay = jitteredBackoff(failures, pollMinBackoff, pollMaxBackoff)
+				failures++
+			}
+			if isConflict(err) {
+				// A leftover webhook blocks polling; another instance polling
+				// with the same token only clears once it stops.
+				if err := r.telegram.DeleteWebhook(); err != nil && r.logger != nil {
+					r.logger.Warn("failed to remove telegram webhook", zap.Error(err))
+				}
+			}
+			if r.logger != nil {
+				r.logger.Warn("failed to poll telegram updates", zap.Error(err), zap.Duration("retry_in", delay))
+			}
+			if !sleepContext(ctx, delay) {
+				return nil
+			}
+			continue
+		}
+		failures = 0
+
+		for _, update := range updates {
+			if update.UpdateID >= offset {
+				offset = update.UpdateID + 1
+			}
+			r.dispatch(ctx, update)
+		}
+	}
+}
+
+// dispatch handles a single update, logging errors and panics so one bad
+// update cannot stop the runner.
+func (r *Runner) dispatch(ctx context.Context, update tgclient.Update) {
+	defer func() {
+		if recovered := recover(); recovered != nil && r.logger != nil {
+			r.logger.Error("panic while handling update", zap.Int("update_id", update.UpdateID), zap.Any("panic", recovered), zap.Stack("stack"))
+		}
+	}()
+
+	if err := r.handleUpdate(ctx, update); err != nil && r.logger != nil {
+		r.logger.Error("failed to handle update", zap.Int("update_id", update.UpdateID), zap.Error(err))
+	}
+}
+
+// retryAfter reports the wait Telegram asked for when rate limiting a call.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if !errors.As(err, &apiErr) || apiErr.RetryAfter <= 0 {
+		return 0, false
+	}
+	return time.Duration(apiErr.RetryAfter) * time.Second, true
+}
+
+// isConflict reports whether polling was refused because a webhook is set or
+// another getUpdates request is running.
+func isConflict(err error) bool {
+	var apiErr *tgbotapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict
+}
+
+// jitteredBackoff doubles min for every failure up to max and picks a random
+// delay in the upper half of that window.
+func jitteredBackoff(failures int, min time.Duration, max time.Duration) time.Duration {
+	delay := min
+	for i := 0; i < failures && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(delay-half)+1))
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
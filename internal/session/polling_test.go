@@ -0,0 +1,42 @@
+package session
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestRetryAfter(t *testing.T) {
+	limited := &tgbotapi.Error{Code: http.StatusTooManyRequests, Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7}}
+	if delay, ok := retryAfter(fmt.Errorf("poll: %w", limited)); !ok || delay != 7*time.Second {
+		t.Fatalf("expected 7s retry, got %v %v", delay, ok)
+	}
+	if _, ok := retryAfter(errors.New("connection reset")); ok {
+		t.Fatalf("expected plain errors not to carry retry_after")
+	}
+
+	if !isConflict(&tgbotapi.Error{Code: http.StatusConflict}) {
+		t.Fatalf("expected 409 to be a conflict")
+	}
+	if isConflict(limited) {
+		t.Fatalf("expected 429 not to be a conflict")
+	}
+}
+
+func TestJitteredBackoff(t *testing.T) {
+	for failures, window := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		for i := 0; i < 20; i++ {
+			delay := jitteredBackoff(failures, time.Second, time.Minute)
+			if delay < window/2 || delay > window {
+				t.Fatalf("failures=%d: delay %v outside [%v, %v]", failures, delay, window/2, window)
+			}
+		}
+	}
+	if delay := jitteredBackoff(30, time.Second, time.Minute); delay > time.Minute || delay < 30*time.Second {
+		t.Fatalf("expected capped delay, got %v", delay)
+	}
+}
@@ -1,6 +1,10 @@
 package session
 
-import "github.com/jomei/notionapi"
+import (
+	"strings"
+
+	"github.com/jomei/notionapi"
+)
 
 const (
 	notionRichTextLimit      = 1990
@@ -55,7 +59,7 @@ func findSentenceBoundary(runes []rune, limit int) int {
 	return limit
 }
 
-func imagePlaceholderBlock(message string) notionapi.Block {
+func placeholderBlock(message string) notionapi.Block {
 	richTexts := []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: message}}}
 	return &notionapi.ParagraphBlock{
 		BasicBlock: notionapi.BasicBlock{Object: "block", Type: "paragraph"},
@@ -82,3 +86,15 @@ func chunkRichText(richTexts []notionapi.RichText, limit int) [][]notionapi.Rich
 	}
 	return chunks
 }
+
+func plainText(richTexts []notionapi.RichText) string {
+	var builder strings.Builder
+	for _, rt := range richTexts {
+		if rt.Text != nil {
+			builder.WriteString(rt.Text.Content)
+		} else {
+			builder.WriteString(rt.PlainText)
+		}
+	}
+	return builder.String()
+}
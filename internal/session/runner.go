@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/jomei/notionapi"
@@ -16,19 +19,24 @@ import (
 )
 
 type Runner struct {
-	cfg          *config.Config
-	telegram     *tgclient.Client
-	notion       *notion.Client
-	github       *github.Client
-	stateMachine *StateMachine
-	mapper       *tgclient.Mapper
-	logger       *zap.Logger
+	cfg           *config.Config
+	telegram      *tgclient.Client
+	pipeline      *Pipeline
+	chatCommands  *Commands
+	stateMachine  *StateMachine
+	mapper        *tgclient.Mapper
+	albums        *AlbumCollector
+	conversations *conversations
+	logger        *zap.Logger
+
+	controlsMu sync.Mutex
+	controls   map[int64]control
 }
 
-const helpText = "Commands:\n/start - start a new capture session\n/clean - clear the current buffer\n/discard - abandon the current session\n/end - create a Notion page and end session\n/help - show this help"
+var _ Platform = (*Runner)(nil)
 
 func NewRunner(cfg *config.Config, logger *zap.Logger) (*Runner, error) {
-	client, err := tgclient.NewClient(cfg.Telegram.Token)
+	client, err := tgclient.NewClient(cfg.Telegram.Token, cfg.Telegram.APIEndpoint, cfg.Telegram.LocalMode)
 	if err != nil {
 		return nil, err
 	}
@@ -37,273 +45,494 @@ func NewRunner(cfg *config.Config, logger *zap.Logger) (*Runner, error) {
 		return nil, err
 	}
 
-	return &Runner{
-		cfg:          cfg,
-		telegram:     client,
-		notion:       notion.NewClient(cfg.Notion.Token),
-		github:       github.NewClient(cfg.GitHub.Token, cfg.GitHub.Repo, cfg.GitHub.BranchForTelegram(), cfg.GitHub.PathPrefix),
-		stateMachine: NewStateMachine(),
-		mapper:       tgclient.NewMapper(),
-		logger:       logger,
-	}, nil
+	stateMachine, err := openStateMachine(cfg, "telegram", logger)
+	if err != nil {
+		return nil, err
+	}
+	outbox, err := openOutbox(cfg, "telegram", logger)
+	if err != nil {
+		return nil, err
+	}
+	if pending := outbox.Len(); pending > 0 && logger != nil {
+		logger.Info("restored outbox", zap.String("platform", "telegram"), zap.Int("pending", pending))
+	}
+	pages, err := openPageLog(cfg, "telegram", logger)
+	if err != nil {
+		return nil, err
+	}
+	conversations, err := openConversations(cfg, "telegram", logger)
+	if err != nil {
+		return nil, err
+	}
+	if restored := stateMachine.Count(); restored > 0 && logger != nil {
+		logger.Info("restored sessions", zap.String("platform", "telegram"), zap.Int("count", restored))
+	}
+
+	r := &Runner{
+		cfg:           cfg,
+		telegram:      client,
+		stateMachine:  stateMachine,
+		mapper:        tgclient.NewMapper(),
+		conversations: conversations,
+		logger:        logger,
+	}
+	githubClient := github.NewClient(cfg.GitHub.Token, cfg.GitHub.Repo, cfg.GitHub.BranchForTelegram(), cfg.GitHub.PathPrefix)
+	r.pipeline = NewPipeline(cfg, r, notion.NewClient(cfg.Notion.Token), githubClient, outbox, pages, logger)
+	r.chatCommands = NewCommands(stateMachine, r.pipeline, logger)
+	r.albums = NewAlbumCollector(albumSettleDelay, r.capture)
+	return r, nil
 }
 
+// Run receives updates until ctx is cancelled or delivery fails for good. It
+// can be called again after it returns; Close releases the runner afterwards.
 func (r *Runner) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	if err := r.registerCommands(); err != nil {
 		if r.logger != nil {
 			r.logger.Warn("failed to register bot commands", zap.Error(err))
 		}
 	}
 
-	offset := 0
-
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		default:
-		}
+	go r.pipeline.RunOutbox(ctx)
+	go NewIdleSweeper(r.cfg.Session, r.stateMachine, r.pipeline, r.logger).Run(ctx)
 
-		updates, err := r.telegram.GetUpdates(offset, 60)
-		if err != nil {
-			return err
-		}
+	var err error
+	if r.cfg.Telegram.Mode == config.TelegramModeWebhook {
+		err = r.serveWebhook(ctx)
+	} else {
+		err = r.poll(ctx)
+	}
 
-		for _, update := range updates {
-			if update.UpdateID >= offset {
-				offset = update.UpdateID + 1
-			}
+	// Albums still settling would otherwise be lost with their timers.
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), albumFlushTimeout)
+	defer cancelFlush()
+	r.albums.Flush(flushCtx)
+	return err
+}
 
-			if err := r.handleUpdate(ctx, update); err != nil {
-				return err
-			}
-		}
-	}
+// Close releases the session store.
+func (r *Runner) Close() error {
+	return r.stateMachine.Close()
 }
 
-func (r *Runner) handleUpdate(ctx context.Context, update tgbotapi.Update) error {
-	if update.Message == nil {
+func (r *Runner) handleUpdate(ctx context.Context, update tgclient.Update) error {
+	if update.ChannelPost != nil {
+		r.handleChannelPost(ctx, update.ChannelPost)
+		return nil
+	}
+	if update.EditedChannelPost != nil {
+		r.handleChannelEdit(update.EditedChannelPost)
+		return nil
+	}
+	if update.CallbackQuery != nil {
+		r.handleCallback(ctx, update.CallbackQuery, update.Topic)
+		return nil
+	}
+	if update.EditedMessage != nil {
+		r.handleEdit(update.EditedMessage, update.Topic)
 		return nil
 	}
 
-	chatID := update.Message.Chat.ID
-	if !r.telegram.IsAllowedChat(r.cfg.Telegram.AllowedChatIDs, chatID) {
+	msg := update.Message
+	if msg == nil || !r.accepts(msg) {
 		return nil
 	}
+	chatID := r.conversationID(msg, update.Topic)
 
-	switch update.Message.Text {
-	case "/help":
-		r.reply(chatID, helpText)
-	case "/start":
-		if !r.stateMachine.IsActive(chatID) {
-			r.stateMachine.StartSession(chatID)
-			r.reply(chatID, "Session started. Send messages or images, then /end to save.")
-			if r.logger != nil {
-				r.logger.Info("session started", zap.Int64("chat_id", chatID))
-			}
-		} else {
-			r.reply(chatID, "Session already active. Use /clean to clear or /end to save.")
+	if msg.IsCommand() {
+		if !r.addressedToBot(msg) {
+			return nil
 		}
-	case "/clean":
-		if r.stateMachine.IsActive(chatID) {
-			r.stateMachine.ClearSession(chatID)
-			r.reply(chatID, "Buffer cleared. Continue sending messages.")
-			if r.logger != nil {
-				r.logger.Info("session cleared", zap.Int64("chat_id", chatID))
-			}
-		} else {
-			r.reply(chatID, "No active session. Use /start first.")
+		command := msg.Command()
+		if confirmedCommands[command] && r.confirm(chatID, command, msg.CommandArguments()) {
+			return nil
 		}
-	case "/discard":
-		if r.stateMachine.IsActive(chatID) {
-			r.stateMachine.DiscardSession(chatID)
-			r.reply(chatID, "Session discarded.")
-			if r.logger != nil {
-				r.logger.Info("session discarded", zap.Int64("chat_id", chatID))
-			}
-		} else {
-			r.reply(chatID, "No active session to discard.")
+		if response, ok := r.chatCommands.Execute(ctx, chatID, command, msg.CommandArguments()); ok {
+			r.reply(chatID, response)
 		}
-	case "/end":
-		if r.stateMachine.IsActive(chatID) {
-			session, ok := r.stateMachine.EndSession(chatID)
-			if ok {
-				if err := r.createNotionPage(ctx, session); err != nil {
-					r.reply(chatID, "Failed to save. Check logs for details. Session remains active for retry.")
-					if r.logger != nil {
-						r.logger.Error("failed to create notion page", zap.Int64("chat_id", chatID), zap.Error(err))
-					}
-					// Keep session active for retry instead of exiting
-					return nil
-				}
-				r.reply(chatID, "Saved to Notion.")
-				if r.logger != nil {
-					r.logger.Info("session ended", zap.Int64("chat_id", chatID))
-				}
-			}
+		if command == "start" || command == "switch" {
+			r.showControl(chatID)
 		} else {
-			r.reply(chatID, "No active session. Use /start first.")
-		}
-	default:
-		if update.Message.Text != "" && strings.HasPrefix(update.Message.Text, "/") {
-			return nil
+			r.refreshControl(chatID)
 		}
-		if !r.stateMachine.IsActive(chatID) {
-			r.stateMachine.StartSession(chatID)
+		return nil
+	}
+	if strings.HasPrefix(msg.Text, "/") {
+		return nil
+	}
+	if !r.triggered(msg) {
+		return nil
+	}
+	msg = r.withoutBotMention(msg)
+
+	switch r.cfg.Session.CaptureMode {
+	case config.CaptureModeJournal, config.CaptureModeInstant:
+		if msg.MediaGroupID != "" {
+			r.albums.Add(chatID, msg.MediaGroupID, r.messageBlocks(msg))
+		} else {
+			r.capture(ctx, chatID, r.messageBlocks(msg))
 		}
-		r.collectMessage(update.Message)
+		return nil
+	}
+
+	// The first message after /end or a discard opens a session on its own,
+	// and gets a control message like /start does.
+	started := !r.stateMachine.IsActive(chatID) && r.stateMachine.StartSession(chatID)
+	r.collectMessage(chatID, msg)
+	if started {
+		r.showControl(chatID)
+	} else {
+		r.refreshControl(chatID)
 	}
 
 	return nil
 }
 
-func (r *Runner) collectMessage(msg *tgbotapi.Message) {
-	if msg.Text != "" {
-		if code := extractCodeBlock(msg); code != nil {
-			r.stateMachine.AppendBlock(msg.Chat.ID, code)
-			return
+// capture saves the blocks of one message, or one album, in journal or
+// instant mode, or mirrors them when they were posted in a channel.
+func (r *Runner) capture(ctx context.Context, chatID int64, blocks []Block) {
+	if conv := r.conversations.lookup(chatID); conv.Channel {
+		r.pipeline.Mirror(ctx, chatID, conv.Title, r.cfg.Telegram.ChannelMode == config.ChannelModeDaily, blocks)
+		return
+	}
+	if r.cfg.Session.CaptureMode == config.CaptureModeJournal {
+		r.pipeline.Journal(ctx, chatID, blocks)
+		return
+	}
+	if response := r.pipeline.Instant(ctx, chatID, blocks); response != "" {
+		r.reply(chatID, response)
+	}
+}
+
+// handleEdit replaces the blocks captured from an edited message. Edits to
+// messages that were never captured are ignored.
+func (r *Runner) handleEdit(msg *tgbotapi.Message, topic int) {
+	if !r.accepts(msg) {
+		return
+	}
+	chatID := r.conversationID(msg, topic)
+	msg = r.withoutBotMention(msg)
+
+	if r.stateMachine.ReplaceMessage(chatID, telegramMessageID(msg), r.messageBlocks(msg)) {
+		if r.logger != nil {
+			r.logger.Debug("updated edited message", zap.Int64("chat_id", chatID), zap.Int("message_id", msg.MessageID))
+		}
+		r.refreshControl(chatID)
+	}
+}
+
+func (r *Runner) collectMessage(chatID int64, msg *tgbotapi.Message) {
+	for _, block := range r.messageBlocks(msg) {
+		r.stateMachine.AppendBlock(chatID, block)
+	}
+}
+
+func (r *Runner) messageBlocks(msg *tgbotapi.Message) []Block {
+	messageID := telegramMessageID(msg)
+	blocks := make([]Block, 0, 1)
+
+	if source, ok := forwardSource(msg); ok {
+		source.MessageID = messageID
+		source.Album = msg.MediaGroupID
+		blocks = append(blocks, source)
+	}
+	if r.cfg != nil && r.cfg.Session.ReplyContext && msg.ReplyToMessage != nil {
+		if quote, ok := r.replyBlock(msg.ReplyToMessage, messageID); ok {
+			blocks = append(blocks, quote)
 		}
+	}
 
-		richText := r.mapper.EntitiesToRichText(msg.Text, msg.Entities)
-		if len(richText) > 0 {
-			r.stateMachine.AppendBlock(msg.Chat.ID, TextBlock{RichText: richText})
+	for _, section := range tgclient.Sections(msg.Text, msg.Entities) {
+		switch section.Kind {
+		case tgclient.SectionCode:
+			blocks = append(blocks, CodeBlock{Content: section.Text, Language: section.Language, MessageID: messageID})
+		case tgclient.SectionQuote:
+			if richText := r.mapper.EntitiesToRichText(section.Text, section.Entities); len(richText) > 0 {
+				blocks = append(blocks, QuoteBlock{RichText: richText, MessageID: messageID})
+			}
+		default:
+			if richText := r.mapper.EntitiesToRichText(section.Text, section.Entities); len(richText) > 0 {
+				blocks = append(blocks, TextBlock{RichText: richText, MessageID: messageID})
+			}
 		}
 	}
 
 	if len(msg.Photo) > 0 {
 		photo := msg.Photo[len(msg.Photo)-1]
-		r.stateMachine.AppendBlock(msg.Chat.ID, ImageBlock{FileID: photo.FileID, Caption: msg.Caption})
+		image := ImageBlock{FileID: photo.FileID, Caption: msg.Caption, MessageID: messageID, Album: msg.MediaGroupID}
+		if len(msg.CaptionEntities) > 0 {
+			image.CaptionRichText = r.mapper.EntitiesToRichText(msg.Caption, msg.CaptionEntities)
+		}
+		blocks = append(blocks, image)
 	}
-}
 
-func (r *Runner) registerCommands() error {
-	commands := []tgbotapi.BotCommand{
-		{Command: "start", Description: "Start a new capture session"},
-		{Command: "clean", Description: "Clear the current buffer"},
-		{Command: "discard", Description: "Discard the current session"},
-		{Command: "end", Description: "Save to Notion and end session"},
-		{Command: "help", Description: "Show available commands"},
+	if file, ok := telegramFile(msg); ok {
+		file.Caption = msg.Caption
+		file.MessageID = messageID
+		file.Album = msg.MediaGroupID
+		if len(msg.CaptionEntities) > 0 {
+			file.CaptionRichText = r.mapper.EntitiesToRichText(msg.Caption, msg.CaptionEntities)
+		}
+		blocks = append(blocks, file)
 	}
 
-	return r.telegram.SetCommands(commands)
-}
+	if msg.Sticker != nil {
+		blocks = append(blocks, r.stickerBlock(msg.Sticker, messageID))
+	}
 
-func (r *Runner) reply(chatID int64, text string) {
-	if err := r.telegram.SendMessage(chatID, text); err != nil {
-		if r.logger != nil {
-			r.logger.Warn("failed to send reply", zap.Int64("chat_id", chatID), zap.Error(err))
-		}
+	if block, ok := telegramShared(msg); ok {
+		blocks = append(blocks, block)
 	}
+
+	return blocks
 }
 
-func extractCodeBlock(msg *tgbotapi.Message) *CodeBlock {
-	if msg.Text == "" {
-		return nil
+// stickerBlock captures a sticker as an image, or as its emoji alone when
+// media.sticker_mode is "emoji".
+func (r *Runner) stickerBlock(sticker *tgbotapi.Sticker, messageID string) Block {
+	if r.cfg != nil && r.cfg.Media.StickerMode == config.StickerModeEmoji && sticker.Emoji != "" {
+		return TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: sticker.Emoji}}}, MessageID: messageID}
 	}
 
-	if len(msg.Entities) != 1 {
-		return nil
+	block := StickerBlock{
+		FileID:    sticker.FileID,
+		Animated:  sticker.IsAnimated,
+		Emoji:     sticker.Emoji,
+		SetName:   sticker.SetName,
+		MessageID: messageID,
 	}
+	if sticker.Thumbnail != nil {
+		block.ThumbnailID = sticker.Thumbnail.FileID
+	}
+	return block
+}
 
-	entity := msg.Entities[0]
-	if entity.Type != "pre" || entity.Offset != 0 || entity.Length != len([]rune(msg.Text)) {
-		return nil
+// telegramShared converts venues, locations, contacts, polls and dice, which
+// carry no text of their own.
+func telegramShared(msg *tgbotapi.Message) (Block, bool) {
+	messageID := telegramMessageID(msg)
+	switch {
+	case msg.Venue != nil:
+		v := msg.Venue
+		return LocationBlock{Latitude: v.Location.Latitude, Longitude: v.Location.Longitude, Title: v.Title, Address: v.Address, MessageID: messageID}, true
+	case msg.Location != nil:
+		return LocationBlock{Latitude: msg.Location.Latitude, Longitude: msg.Location.Longitude, MessageID: messageID}, true
+	case msg.Contact != nil:
+		c := msg.Contact
+		return ContactBlock{FirstName: c.FirstName, LastName: c.LastName, PhoneNumber: c.PhoneNumber, MessageID: messageID}, true
+	case msg.Poll != nil:
+		p := msg.Poll
+		options := make([]PollOption, 0, len(p.Options))
+		for _, option := range p.Options {
+			options = append(options, PollOption{Text: option.Text, Votes: option.VoterCount})
+		}
+		return PollBlock{Question: p.Question, Options: options, TotalVotes: p.TotalVoterCount, Closed: p.IsClosed, MessageID: messageID}, true
+	case msg.Dice != nil:
+		text := fmt.Sprintf("%s rolled %d", msg.Dice.Emoji, msg.Dice.Value)
+		return TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: text}}}, MessageID: messageID}, true
 	}
+	return nil, false
+}
 
-	return &CodeBlock{Content: msg.Text, Language: entity.Language}
+// replyBlock quotes the message a reply answers. Media without a caption is
+// named instead, so the quote never comes out empty.
+func (r *Runner) replyBlock(reply *tgbotapi.Message, messageID string) (QuoteBlock, bool) {
+	text, entities := reply.Text, reply.Entities
+	if text == "" {
+		text, entities = reply.Caption, reply.CaptionEntities
+	}
+	richText := r.mapper.EntitiesToRichText(text, entities)
+	if len(richText) == 0 {
+		if label := telegramMediaLabel(reply); label != "" {
+			richText = []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: label}}}
+		}
+	}
+	return replyQuote(telegramAuthor(reply), richText, messageID)
 }
 
-func (r *Runner) createNotionPage(ctx context.Context, session *Session) error {
-	loc, err := r.cfg.Title.Location()
-	if err != nil {
-		return err
+// telegramAuthor names who sent msg: the user, or the chat posting as itself.
+func telegramAuthor(msg *tgbotapi.Message) string {
+	if msg.SenderChat != nil {
+		return msg.SenderChat.Title
+	}
+	if msg.From == nil {
+		return ""
 	}
+	if name := strings.TrimSpace(msg.From.FirstName + " " + msg.From.LastName); name != "" {
+		return name
+	}
+	return msg.From.UserName
+}
 
-	blocks := make([]notionapi.Block, 0, len(session.Blocks))
+// telegramMediaLabel describes the media of a message without text.
+func telegramMediaLabel(msg *tgbotapi.Message) string {
+	if len(msg.Photo) > 0 {
+		return "[photo]"
+	}
+	if msg.Sticker != nil {
+		return msg.Sticker.Emoji
+	}
+	if file, ok := telegramFile(msg); ok {
+		if file.Filename != "" {
+			return "[" + file.Media + ": " + file.Filename + "]"
+		}
+		return "[" + file.Media + "]"
+	}
+	if shared, ok := telegramShared(msg); ok {
+		if text, ok := shared.(TextBlock); ok {
+			return plainText(text.RichText)
+		}
+		return "[" + shared.Kind() + "]"
+	}
+	return ""
+}
 
-	for _, block := range session.Blocks {
-		switch b := block.(type) {
-		case TextBlock:
-			richTexts := splitRichTextEntries(b.RichText)
-			for _, chunk := range chunkRichText(richTexts, notionRichTextBlockLimit) {
-				blocks = append(blocks, &notionapi.ParagraphBlock{
-					BasicBlock: notionapi.BasicBlock{Object: "block", Type: "paragraph"},
-					Paragraph:  notionapi.Paragraph{RichText: chunk},
-				})
-			}
-		case CodeBlock:
-			language := b.Language
-			if language == "" {
-				language = "plain text"
-			}
-			richTexts := splitRichTextEntries([]notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: b.Content}}})
-			for _, chunk := range chunkRichText(richTexts, notionRichTextBlockLimit) {
-				blocks = append(blocks, &notionapi.CodeBlock{
-					BasicBlock: notionapi.BasicBlock{Object: "block", Type: "code"},
-					Code: notionapi.Code{
-						RichText: chunk,
-						Language: language,
-					},
-				})
-			}
-		case ImageBlock:
-			fileURL := b.FileURL
-			if fileURL == "" {
-				if b.FileID == "" {
-					continue
-				}
-				resolvedURL, err := r.telegram.GetFileURL(b.FileID)
-				if err != nil {
-					return err
-				}
-				fileURL = resolvedURL
+// forwardSource describes where a forwarded message came from: the channel
+// post, the original sender, or just the name a hidden sender left behind.
+func forwardSource(msg *tgbotapi.Message) (SourceBlock, bool) {
+	var source SourceBlock
+	switch {
+	case msg.ForwardFromChat != nil:
+		chat := msg.ForwardFromChat
+		source.Name = chat.Title
+		if source.Name == "" {
+			source.Name = chat.UserName
+		}
+		if msg.ForwardSignature != "" {
+			source.Name += " (" + msg.ForwardSignature + ")"
+		}
+		source.URL = telegramPostURL(chat, msg.ForwardFromMessageID)
+	case msg.ForwardFrom != nil:
+		user := msg.ForwardFrom
+		source.Name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		if user.UserName != "" {
+			if source.Name == "" {
+				source.Name = "@" + user.UserName
 			}
+			source.URL = "https://t.me/" + user.UserName
+		}
+	case msg.ForwardSenderName != "":
+		source.Name = msg.ForwardSenderName
+	default:
+		return SourceBlock{}, false
+	}
 
-			data, extension, err := downloadImage(ctx, fileURL)
-			if err != nil {
-				if errors.Is(err, ErrImageTooLarge) {
-					r.reply(session.ChatID, imageTooLargeMessage)
-					blocks = append(blocks, imagePlaceholderBlock(imageTooLargeMessage))
-					continue
-				}
-				if errors.Is(err, ErrUnsupportedImageType) {
-					r.reply(session.ChatID, imageUnsupportedTypeMessage)
-					blocks = append(blocks, imagePlaceholderBlock(imageUnsupportedTypeMessage))
-					continue
-				}
-				return err
-			}
+	if msg.ForwardDate > 0 {
+		source.Date = time.Unix(int64(msg.ForwardDate), 0)
+	}
+	return source, true
+}
 
-			rawURL, err := r.github.UploadImage(ctx, data, extension)
-			if err != nil {
-				return err
-			}
+// telegramPostURL links to a message in a channel or supergroup. Chats without
+// a public username use the t.me/c form, which only works for members.
+func telegramPostURL(chat *tgbotapi.Chat, messageID int) string {
+	base := ""
+	if chat.UserName != "" {
+		base = "https://t.me/" + chat.UserName
+	} else if id := strconv.FormatInt(chat.ID, 10); strings.HasPrefix(id, "-100") {
+		base = "https://t.me/c/" + strings.TrimPrefix(id, "-100")
+	}
+	if base == "" || messageID == 0 {
+		return base
+	}
+	return base + "/" + strconv.Itoa(messageID)
+}
 
-			image := &notionapi.ImageBlock{
-				BasicBlock: notionapi.BasicBlock{Object: "block", Type: "image"},
-				Image: notionapi.Image{
-					External: &notionapi.FileObject{URL: rawURL},
-				},
-			}
+// telegramFile picks the file a message carries besides photos. Animations
+// also fill Document for older clients, so they are checked first.
+func telegramFile(msg *tgbotapi.Message) (FileBlock, bool) {
+	switch {
+	case msg.Animation != nil:
+		a := msg.Animation
+		return FileBlock{FileID: a.FileID, Filename: a.FileName, MimeType: a.MimeType, Size: int64(a.FileSize), Media: MediaAnimation}, true
+	case msg.Video != nil:
+		v := msg.Video
+		return FileBlock{FileID: v.FileID, Filename: v.FileName, MimeType: v.MimeType, Size: int64(v.FileSize), Media: MediaVideo}, true
+	case msg.VideoNote != nil:
+		v := msg.VideoNote
+		return FileBlock{FileID: v.FileID, MimeType: "video/mp4", Size: int64(v.FileSize), Media: MediaVideo}, true
+	case msg.Audio != nil:
+		a := msg.Audio
+		return FileBlock{FileID: a.FileID, Filename: a.FileName, MimeType: a.MimeType, Size: int64(a.FileSize), Media: MediaAudio}, true
+	case msg.Voice != nil:
+		v := msg.Voice
+		return FileBlock{FileID: v.FileID, MimeType: v.MimeType, Size: int64(v.FileSize), Media: MediaVoice}, true
+	case msg.Document != nil:
+		d := msg.Document
+		return FileBlock{FileID: d.FileID, Filename: d.FileName, MimeType: d.MimeType, Size: int64(d.FileSize), Media: MediaDocument}, true
+	}
+	return FileBlock{}, false
+}
 
-			if b.Caption != "" {
-				image.Image.Caption = []notionapi.RichText{
-					{Type: "text", Text: &notionapi.Text{Content: b.Caption}},
-				}
-			}
+func (r *Runner) registerCommands() error {
+	commands := []tgbotapi.BotCommand{
+		{Command: "start", Description: "Start a new capture session"},
+		{Command: "switch", Description: "Switch the active session"},
+		{Command: "sessions", Description: "List sessions"},
+		{Command: "preview", Description: "Show what is buffered"},
+		{Command: "undo", Description: "Remove the last n blocks"},
+		{Command: "clean", Description: "Clear the current buffer"},
+		{Command: "discard", Description: "Discard a session"},
+		{Command: "end", Description: "Save to Notion and end session"},
+		{Command: "append", Description: "Append the session to an existing page"},
+		{Command: "last", Description: "Show the most recently saved page"},
+		{Command: "help", Description: "Show available commands"},
+	}
 
-			blocks = append(blocks, image)
+	return r.telegram.SetCommands(commands)
+}
+
+// reply sends text to the chat, and forum topic, behind chatID. Mirrored
+// channels are never written to.
+func (r *Runner) reply(chatID int64, text string) {
+	conv := r.conversations.lookup(chatID)
+	if conv.Channel {
+		if r.logger != nil {
+			r.logger.Info("not replying in channel", zap.Int64("chat_id", conv.ChatID), zap.String("message", text))
 		}
+		return
 	}
-
-	if len(blocks) == 0 {
-		return fmt.Errorf("no blocks to save")
+	var err error
+	if conv.Topic != 0 {
+		err = r.telegram.SendTopicMessage(conv.ChatID, conv.Topic, text)
+	} else {
+		err = r.telegram.SendMessage(conv.ChatID, text)
+	}
+	if err != nil {
+		if r.logger != nil {
+			r.logger.Warn("failed to send reply", zap.Int64("chat_id", conv.ChatID), zap.Int("topic", conv.Topic), zap.Error(err))
+		}
 	}
+}
+
+func telegramMessageID(msg *tgbotapi.Message) string {
+	return strconv.Itoa(msg.MessageID)
+}
+
+func (r *Runner) Origin() string {
+	return "Telegram"
+}
 
-	title := r.cfg.Title.FormatTime(loc)
-	if r.logger != nil {
-		r.logger.Info("creating notion page", zap.String("origin_property", r.cfg.Notion.OriginProperty), zap.String("origin", "Telegram"))
+func (r *Runner) ResolveMedia(ctx context.Context, fileID string, fileURL string) (string, error) {
+	if fileURL != "" {
+		return fileURL, nil
 	}
-	_, err = r.notion.CreatePage(ctx, r.cfg.Notion.DatabaseID, r.cfg.Notion.TitleProperty, r.cfg.Notion.OriginProperty, title, "Telegram", blocks)
-	return err
+	if fileID == "" {
+		return "", nil
+	}
+	fileURL, err := r.telegram.GetFileURL(fileID)
+	if errors.Is(err, tgclient.ErrFileTooBig) {
+		return "", fmt.Errorf("%w: %v", ErrFileTooLarge, err)
+	}
+	return fileURL, err
+}
+
+func (r *Runner) Notify(chatID int64, message string) {
+	r.reply(chatID, message)
+}
+
+func (r *Runner) ChatField(chatID int64) zap.Field {
+	return zap.Int64("chat_id", chatID)
 }
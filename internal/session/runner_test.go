@@ -0,0 +1,223 @@
+package session
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"github.com/nerdneilsfield/telenotion-bot/internal/tgclient"
+)
+
+func TestRunnerMessageBlocks(t *testing.T) {
+	r := &Runner{mapper: tgclient.NewMapper()}
+
+	code := &tgbotapi.Message{
+		MessageID: 10,
+		Text:      "x := 1",
+		Entities:  []tgbotapi.MessageEntity{{Type: "pre", Offset: 0, Length: 6, Language: "go"}},
+	}
+	blocks := r.messageBlocks(code)
+	if len(blocks) != 1 {
+		t.Fatalf("expected 1 block, got %d", len(blocks))
+	}
+	codeBlock, ok := blocks[0].(CodeBlock)
+	if !ok || codeBlock.Language != "go" || codeBlock.Source() != "10" {
+		t.Fatalf("unexpected code block: %#v", blocks[0])
+	}
+
+	quoted := &tgbotapi.Message{
+		MessageID: 12,
+		Text:      "said:\nhello there",
+		Entities:  []tgbotapi.MessageEntity{{Type: "blockquote", Offset: 6, Length: 11}},
+	}
+	blocks = r.messageBlocks(quoted)
+	if len(blocks) != 2 {
+		t.Fatalf("expected text and quote blocks, got %#v", blocks)
+	}
+	if quote, ok := blocks[1].(QuoteBlock); !ok || plainText(quote.RichText) != "hello there" || quote.Source() != "12" {
+		t.Fatalf("unexpected quote block: %#v", blocks[1])
+	}
+
+	photo := &tgbotapi.Message{
+		MessageID: 11,
+		Caption:   "cat",
+		Photo:     []tgbotapi.PhotoSize{{FileID: "small"}, {FileID: "large"}},
+	}
+	blocks = r.messageBlocks(photo)
+	image, ok := blocks[0].(ImageBlock)
+	if len(blocks) != 1 || !ok || image.FileID != "large" || image.Source() != "11" {
+		t.Fatalf("unexpected image blocks: %#v", blocks)
+	}
+
+	photo.MediaGroupID = "album"
+	if image := r.messageBlocks(photo)[0].(ImageBlock); image.Album != "album" {
+		t.Fatalf("expected album to be kept, got %#v", image)
+	}
+
+	photo.Caption = "see docs"
+	photo.CaptionEntities = []tgbotapi.MessageEntity{{Type: "text_link", Offset: 4, Length: 4, URL: "https://example.com"}}
+	image = r.messageBlocks(photo)[0].(ImageBlock)
+	caption := imageCaption(image)
+	if len(caption) != 2 || caption[1].Text.Link == nil || caption[1].Text.Link.Url != "https://example.com" {
+		t.Fatalf("expected linked caption, got %#v", caption)
+	}
+
+	document := &tgbotapi.Message{
+		MessageID: 13,
+		Caption:   "notes",
+		Document:  &tgbotapi.Document{FileID: "doc", FileName: "notes.pdf", MimeType: "application/pdf", FileSize: 42},
+	}
+	blocks = r.messageBlocks(document)
+	file, ok := blocks[0].(FileBlock)
+	if len(blocks) != 1 || !ok || file.Media != MediaDocument || file.Filename != "notes.pdf" || file.Size != 42 || file.Caption != "notes" {
+		t.Fatalf("unexpected document blocks: %#v", blocks)
+	}
+
+	animation := &tgbotapi.Message{
+		MessageID: 14,
+		Animation: &tgbotapi.Animation{FileID: "gif", MimeType: "video/mp4"},
+		Document:  &tgbotapi.Document{FileID: "gif", MimeType: "video/mp4"},
+	}
+	blocks = r.messageBlocks(animation)
+	if file, ok := blocks[0].(FileBlock); len(blocks) != 1 || !ok || file.Media != MediaAnimation {
+		t.Fatalf("unexpected animation blocks: %#v", blocks)
+	}
+}
+
+func TestForwardSource(t *testing.T) {
+	post := &tgbotapi.Message{
+		ForwardFromChat:      &tgbotapi.Chat{ID: -1001234, Title: "News", UserName: "news"},
+		ForwardFromMessageID: 42,
+		ForwardDate:          1700000000,
+	}
+	source, ok := forwardSource(post)
+	if !ok || source.Name != "News" || source.URL != "https://t.me/news/42" || source.Date.Unix() != 1700000000 {
+		t.Fatalf("unexpected channel source: %#v", source)
+	}
+
+	post.ForwardFromChat = &tgbotapi.Chat{ID: -1001234, Title: "Private"}
+	if source, _ := forwardSource(post); source.URL != "https://t.me/c/1234/42" {
+		t.Fatalf("unexpected private channel link %q", source.URL)
+	}
+
+	hidden := &tgbotapi.Message{ForwardSenderName: "Someone", Text: "hi"}
+	r := &Runner{mapper: tgclient.NewMapper()}
+	blocks := r.messageBlocks(hidden)
+	if len(blocks) != 2 {
+		t.Fatalf("expected source and text blocks, got %#v", blocks)
+	}
+	if source, ok := blocks[0].(SourceBlock); !ok || source.Name != "Someone" || source.URL != "" {
+		t.Fatalf("unexpected hidden sender source: %#v", blocks[0])
+	}
+
+	if _, ok := forwardSource(&tgbotapi.Message{Text: "own"}); ok {
+		t.Fatal("expected no source for a message that was not forwarded")
+	}
+}
+
+func TestRunnerMessageBlocksReplyContext(t *testing.T) {
+	r := &Runner{cfg: &config.Config{}, mapper: tgclient.NewMapper()}
+	reply := &tgbotapi.Message{
+		MessageID: 21,
+		Text:      "agreed",
+		ReplyToMessage: &tgbotapi.Message{
+			MessageID: 20,
+			From:      &tgbotapi.User{FirstName: "Ada"},
+			Text:      "ship it?",
+		},
+	}
+
+	if blocks := r.messageBlocks(reply); len(blocks) != 1 {
+		t.Fatalf("expected reply context to be off by default, got %#v", blocks)
+	}
+
+	r.cfg.Session.ReplyContext = true
+	blocks := r.messageBlocks(reply)
+	if len(blocks) != 2 {
+		t.Fatalf("expected quote and text blocks, got %#v", blocks)
+	}
+	quote, ok := blocks[0].(QuoteBlock)
+	if !ok || plainText(quote.RichText) != "Ada: ship it?" || quote.Source() != "21" {
+		t.Fatalf("unexpected reply quote: %#v", blocks[0])
+	}
+
+	reply.ReplyToMessage = &tgbotapi.Message{Photo: []tgbotapi.PhotoSize{{FileID: "p"}}}
+	if quote := r.messageBlocks(reply)[0].(QuoteBlock); plainText(quote.RichText) != "[photo]" {
+		t.Fatalf("unexpected media reply quote: %#v", quote)
+	}
+}
+
+func TestTelegramShared(t *testing.T) {
+	venue := &tgbotapi.Message{MessageID: 30, Venue: &tgbotapi.Venue{Location: tgbotapi.Location{Latitude: 1.5, Longitude: 2.25}, Title: "Cafe", Address: "Main St"}, Location: &tgbotapi.Location{Latitude: 1.5, Longitude: 2.25}}
+	block, ok := telegramShared(venue)
+	if location, isLocation := block.(LocationBlock); !ok || !isLocation || location.Title != "Cafe" || location.Source() != "30" {
+		t.Fatalf("unexpected venue block: %#v", block)
+	}
+
+	poll := &tgbotapi.Message{Poll: &tgbotapi.Poll{Question: "Lunch?", TotalVoterCount: 3, Options: []tgbotapi.PollOption{{Text: "Yes", VoterCount: 2}, {Text: "No", VoterCount: 1}}}}
+	block, _ = telegramShared(poll)
+	if p, ok := block.(PollBlock); !ok || len(p.Options) != 2 || p.Options[0].Votes != 2 || p.TotalVotes != 3 {
+		t.Fatalf("unexpected poll block: %#v", block)
+	}
+
+	dice := &tgbotapi.Message{Dice: &tgbotapi.Dice{Emoji: "🎲", Value: 4}}
+	block, _ = telegramShared(dice)
+	if text, ok := block.(TextBlock); !ok || plainText(text.RichText) != "🎲 rolled 4" {
+		t.Fatalf("unexpected dice block: %#v", block)
+	}
+}
+
+func TestRunnerStickerBlock(t *testing.T) {
+	r := &Runner{cfg: &config.Config{}, mapper: tgclient.NewMapper()}
+	sticker := &tgbotapi.Sticker{FileID: "tgs", IsAnimated: true, Emoji: "🎉", Thumbnail: &tgbotapi.PhotoSize{FileID: "thumb"}}
+
+	block, ok := r.stickerBlock(sticker, "40").(StickerBlock)
+	if !ok || !block.Animated || block.ThumbnailID != "thumb" || block.Source() != "40" {
+		t.Fatalf("unexpected sticker block: %#v", block)
+	}
+
+	r.cfg.Media.StickerMode = config.StickerModeEmoji
+	text, ok := r.stickerBlock(sticker, "40").(TextBlock)
+	if !ok || plainText(text.RichText) != "🎉" {
+		t.Fatalf("expected emoji text block, got %#v", text)
+	}
+}
+
+func TestRunnerAutoStartShowsControl(t *testing.T) {
+	fake, client := newFakeBotAPI(t, false)
+	cfg := &config.Config{Telegram: config.Telegram{AllowedChatIDs: []int64{1}}}
+	r := &Runner{cfg: cfg, telegram: client, stateMachine: NewStateMachine(), mapper: tgclient.NewMapper()}
+	message := func(id int, text string) tgclient.Update {
+		return tgclient.Update{Update: tgbotapi.Update{Message: &tgbotapi.Message{
+			MessageID: id,
+			Chat:      &tgbotapi.Chat{ID: 1, Type: "private"},
+			Text:      text,
+		}}}
+	}
+
+	if err := r.handleUpdate(context.Background(), message(1, "first")); err != nil {
+		t.Fatalf("handleUpdate() error = %v", err)
+	}
+	sent := fake.calls("sendMessage")
+	if len(sent) != 1 || sent[0].text != `Session "default": 1 block` || !strings.Contains(sent[0].markup, `"save"`) {
+		t.Fatalf("expected a control message with buttons, got %#v", sent)
+	}
+
+	if err := r.handleUpdate(context.Background(), message(2, "second")); err != nil {
+		t.Fatalf("handleUpdate() error = %v", err)
+	}
+	deadline := time.Now().Add(3 * controlRefreshDelay)
+	for len(fake.calls("editMessageText")) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	edits := fake.calls("editMessageText")
+	if len(edits) != 1 || edits[0].text != `Session "default": 2 blocks` {
+		t.Fatalf("expected the control message to show 2 blocks, got %#v", edits)
+	}
+	if sent := fake.calls("sendMessage"); len(sent) != 1 {
+		t.Fatalf("expected no further messages, got %#v", sent)
+	}
+}
@@ -1,6 +1,37 @@
 package session
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoBlocks is returned when a session has nothing that can be saved.
+var ErrNoBlocks = errors.New("no blocks to save")
+
 type Session struct {
 	ChatID int64
+	// Name distinguishes parallel sessions within a chat.
+	Name   string
 	Blocks []Block
+	// UpdatedAt is the time of the last change, used for idle timeouts.
+	UpdatedAt time.Time
+	// Reminded is set once an idle reminder was sent and cleared on activity.
+	Reminded bool
+	// AppendTo is the Notion page the blocks are appended to instead of
+	// creating a new page.
+	AppendTo string
+	// JournalTitle names the day page the blocks are appended to in journal
+	// capture mode.
+	JournalTitle string
+	// Written counts the Notion blocks an earlier attempt already put on
+	// AppendTo before failing; a retry appends only the rest.
+	Written int
+	// CreatedTitle is the title of the page an earlier attempt created before
+	// failing, when that page is AppendTo, so the finished save is still
+	// reported as a new page.
+	CreatedTitle string
+}
+
+func (s *Session) key() Key {
+	return Key{ChatID: s.ChatID, Name: s.Name}
 }
@@ -1,38 +1,145 @@
 package session
 
-import "sync"
+import (
+	"sort"
+	"sync"
+	"time"
 
+	"go.uber.org/zap"
+)
+
+// DefaultSessionName is used when a session is started without a name.
+const DefaultSessionName = "default"
+
+// Key identifies a named session within a chat.
+type Key struct {
+	ChatID int64
+	Name   string
+}
+
+// StateMachine tracks the named sessions of every chat. Each chat with at
+// least one session has exactly one active session that receives messages.
 type StateMachine struct {
 	mu       sync.RWMutex
-	sessions map[int64]*Session
+	sessions map[Key]*Session
+	active   map[int64]string
+	store    Store
+	logger   *zap.Logger
+	now      func() time.Time
 }
 
 func NewStateMachine() *StateMachine {
-	return &StateMachine{sessions: make(map[int64]*Session)}
+	return &StateMachine{sessions: make(map[Key]*Session), active: make(map[int64]string), now: time.Now}
 }
 
+// NewPersistentStateMachine restores sessions from store and writes every
+// subsequent change through to it. The most recently updated session of each
+// chat becomes its active session. Sessions saved before sessions had names
+// are moved to the default session in the store as well.
+func NewPersistentStateMachine(store Store, logger *zap.Logger) (*StateMachine, error) {
+	sessions, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	sm := NewStateMachine()
+	sm.store = store
+	sm.logger = logger
+	var legacy []*Session
+	for _, session := range sessions {
+		if session.Name == "" {
+			legacy = append(legacy, session)
+			continue
+		}
+		if session.UpdatedAt.IsZero() {
+			session.UpdatedAt = sm.now()
+		}
+		sm.sessions[session.key()] = session
+	}
+	for _, session := range legacy {
+		sm.migrate(session)
+	}
+	for key := range sm.sessions {
+		if _, ok := sm.active[key.ChatID]; !ok {
+			sm.activateLatest(key.ChatID)
+		}
+	}
+	return sm, nil
+}
+
+// StartSession starts the default session unless the chat already has an
+// active session.
 func (sm *StateMachine) StartSession(chatID int64) bool {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if _, exists := sm.sessions[chatID]; exists {
+	if _, exists := sm.active[chatID]; exists {
 		return false
 	}
 
-	sm.sessions[chatID] = &Session{ChatID: chatID, Blocks: []Block{}}
+	sm.create(chatID, DefaultSessionName)
 	return true
 }
 
+// StartNamedSession creates the named session and makes it active. It fails
+// if the chat already has a session with that name.
+func (sm *StateMachine) StartNamedSession(chatID int64, name string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	if _, exists := sm.sessions[Key{ChatID: chatID, Name: name}]; exists {
+		return false
+	}
+
+	sm.create(chatID, name)
+	return true
+}
+
+// SwitchSession makes an existing named session active.
+func (sm *StateMachine) SwitchSession(chatID int64, name string) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, exists := sm.sessions[Key{ChatID: chatID, Name: name}]
+	if !exists {
+		return false
+	}
+
+	sm.active[chatID] = name
+	sm.touch(session)
+	sm.persist(session)
+	return true
+}
+
+// Sessions returns snapshots of the chat's sessions ordered by name.
+func (sm *StateMachine) Sessions(chatID int64) []*Session {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	sessions := make([]*Session, 0)
+	for key, session := range sm.sessions {
+		if key.ChatID == chatID {
+			sessions = append(sessions, snapshot(session))
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].Name < sessions[j].Name
+	})
+	return sessions
+}
+
 func (sm *StateMachine) ClearSession(chatID int64) bool {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, exists := sm.sessions[chatID]
+	session, exists := sm.activeSession(chatID)
 	if !exists {
 		return false
 	}
 
 	session.Blocks = []Block{}
+	sm.touch(session)
+	sm.persist(session)
 	return true
 }
 
@@ -40,50 +147,278 @@ func (sm *StateMachine) DiscardSession(chatID int64) bool {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	if _, exists := sm.sessions[chatID]; !exists {
+	session, exists := sm.activeSession(chatID)
+	if !exists {
 		return false
 	}
 
-	delete(sm.sessions, chatID)
+	sm.remove(session.key())
 	return true
 }
 
+// EndSession removes and returns the chat's active session.
 func (sm *StateMachine) EndSession(chatID int64) (*Session, bool) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, exists := sm.sessions[chatID]
+	session, exists := sm.activeSession(chatID)
 	if !exists {
 		return nil, false
 	}
 
-	delete(sm.sessions, chatID)
+	sm.remove(session.key())
 	return session, true
 }
 
+// EndNamedSession removes and returns the named session.
+func (sm *StateMachine) EndNamedSession(chatID int64, name string) (*Session, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	key := Key{ChatID: chatID, Name: name}
+	session, exists := sm.sessions[key]
+	if !exists {
+		return nil, false
+	}
+
+	sm.remove(key)
+	return session, true
+}
+
+// GetSession returns a snapshot of the chat's active session.
 func (sm *StateMachine) GetSession(chatID int64) *Session {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	return sm.sessions[chatID]
+	session, exists := sm.activeSession(chatID)
+	if !exists {
+		return nil
+	}
+	return snapshot(session)
 }
 
 func (sm *StateMachine) IsActive(chatID int64) bool {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 
-	_, exists := sm.sessions[chatID]
+	_, exists := sm.active[chatID]
 	return exists
 }
 
+// AppendBlock adds a block to the chat's active session.
 func (sm *StateMachine) AppendBlock(chatID int64, block Block) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	session, exists := sm.sessions[chatID]
+	session, exists := sm.activeSession(chatID)
 	if !exists {
 		return
 	}
 
 	session.Blocks = append(session.Blocks, block)
+	sm.touch(session)
+	sm.persist(session)
+}
+
+// UndoBlocks removes up to n of the most recent blocks from the chat's active
+// session and returns how many were removed.
+func (sm *StateMachine) UndoBlocks(chatID int64, n int) (int, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, exists := sm.activeSession(chatID)
+	if !exists {
+		return 0, false
+	}
+
+	if n > len(session.Blocks) {
+		n = len(session.Blocks)
+	}
+	if n <= 0 {
+		return 0, true
+	}
+
+	session.Blocks = session.Blocks[:len(session.Blocks)-n]
+	sm.touch(session)
+	sm.persist(session)
+	return n, true
+}
+
+// ReplaceMessage swaps the blocks captured from messageID, in whichever of the
+// chat's sessions holds them, for blocks. It reports whether the message was
+// found.
+func (sm *StateMachine) ReplaceMessage(chatID int64, messageID string, blocks []Block) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	for key, session := range sm.sessions {
+		if key.ChatID != chatID {
+			continue
+		}
+		replaced, found := replaceSource(session.Blocks, messageID, blocks)
+		if !found {
+			continue
+		}
+		session.Blocks = replaced
+		sm.touch(session)
+		sm.persist(session)
+		return true
+	}
+	return false
+}
+
+// RemoveMessage drops the blocks captured from messageID.
+func (sm *StateMachine) RemoveMessage(chatID int64, messageID string) bool {
+	return sm.ReplaceMessage(chatID, messageID, nil)
+}
+
+// Count returns the number of sessions across all chats.
+func (sm *StateMachine) Count() int {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	return len(sm.sessions)
+}
+
+// IdleSessions returns the sessions that have not changed since cutoff and
+// have not been reminded yet.
+func (sm *StateMachine) IdleSessions(cutoff time.Time) []Key {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	idle := make([]Key, 0)
+	for key, session := range sm.sessions {
+		if !session.Reminded && session.UpdatedAt.Before(cutoff) {
+			idle = append(idle, key)
+		}
+	}
+	return idle
+}
+
+// EndIdleSession ends the session only if it is still idle at cutoff, so a
+// message racing the sweeper keeps the session alive.
+func (sm *StateMachine) EndIdleSession(key Key, cutoff time.Time) (*Session, bool) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, exists := sm.sessions[key]
+	if !exists || !session.UpdatedAt.Before(cutoff) {
+		return nil, false
+	}
+
+	sm.remove(key)
+	return session, true
+}
+
+// MarkReminded flags an idle session as reminded so it is not reported again
+// until new activity arrives.
+func (sm *StateMachine) MarkReminded(key Key, cutoff time.Time) bool {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	session, exists := sm.sessions[key]
+	if !exists || session.Reminded || !session.UpdatedAt.Before(cutoff) {
+		return false
+	}
+
+	session.Reminded = true
+	sm.persist(session)
+	return true
+}
+
+// Close releases the underlying store, if any.
+func (sm *StateMachine) Close() error {
+	if sm.store == nil {
+		return nil
+	}
+	return sm.store.Close()
+}
+
+// migrate stores a session saved without a name as the chat's default
+// session and drops the unnamed record. When the chat already has a default
+// session the unnamed record is stale and only dropped.
+func (sm *StateMachine) migrate(session *Session) {
+	legacyKey := session.key()
+	session.Name = DefaultSessionName
+	if _, exists := sm.sessions[session.key()]; !exists {
+		if session.UpdatedAt.IsZero() {
+			session.UpdatedAt = sm.now()
+		}
+		sm.sessions[session.key()] = session
+		sm.persist(session)
+	}
+	sm.forget(legacyKey)
+}
+
+// snapshot copies session so callers can read it without holding the lock.
+func snapshot(session *Session) *Session {
+	copied := *session
+	copied.Blocks = append([]Block(nil), session.Blocks...)
+	return &copied
+}
+
+func (sm *StateMachine) activeSession(chatID int64) (*Session, bool) {
+	name, exists := sm.active[chatID]
+	if !exists {
+		return nil, false
+	}
+	session, exists := sm.sessions[Key{ChatID: chatID, Name: name}]
+	return session, exists
+}
+
+func (sm *StateMachine) create(chatID int64, name string) {
+	session := &Session{ChatID: chatID, Name: name, Blocks: []Block{}, UpdatedAt: sm.now()}
+	sm.sessions[session.key()] = session
+	sm.active[chatID] = name
+	sm.persist(session)
+}
+
+// remove deletes a session and, if it was active, hands activity to the
+// chat's most recently updated remaining session.
+func (sm *StateMachine) remove(key Key) {
+	delete(sm.sessions, key)
+	sm.forget(key)
+
+	if sm.active[key.ChatID] == key.Name {
+		delete(sm.active, key.ChatID)
+		sm.activateLatest(key.ChatID)
+	}
+}
+
+func (sm *StateMachine) activateLatest(chatID int64) {
+	var latest *Session
+	for key, session := range sm.sessions {
+		if key.ChatID != chatID {
+			continue
+		}
+		if latest == nil || session.UpdatedAt.After(latest.UpdatedAt) {
+			latest = session
+		}
+	}
+	if latest != nil {
+		sm.active[chatID] = latest.Name
+	}
+}
+
+func (sm *StateMachine) touch(session *Session) {
+	session.UpdatedAt = sm.now()
+	session.Reminded = false
+}
+
+func (sm *StateMachine) persist(session *Session) {
+	if sm.store == nil {
+		return
+	}
+	if err := sm.store.Save(session); err != nil && sm.logger != nil {
+		sm.logger.Error("failed to persist session", zap.Int64("chat_id", session.ChatID), zap.String("session", session.Name), zap.Error(err))
+	}
+}
+
+func (sm *StateMachine) forget(key Key) {
+	if sm.store == nil {
+		return
+	}
+	if err := sm.store.Delete(key); err != nil && sm.logger != nil {
+		sm.logger.Error("failed to delete persisted session", zap.Int64("chat_id", key.ChatID), zap.String("session", key.Name), zap.Error(err))
+	}
 }
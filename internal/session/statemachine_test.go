@@ -41,3 +41,88 @@ func TestStateMachineLifecycle(t *testing.T) {
 		t.Fatalf("expected DiscardSession to fail on inactive")
 	}
 }
+
+func TestStateMachineNamedSessions(t *testing.T) {
+	sm := NewStateMachine()
+	chatID := int64(123)
+
+	if !sm.StartNamedSession(chatID, "reading") {
+		t.Fatalf("expected StartNamedSession to succeed")
+	}
+	sm.AppendBlock(chatID, TextBlock{})
+	if !sm.StartNamedSession(chatID, "bug") {
+		t.Fatalf("expected second named session to start")
+	}
+	if sm.StartNamedSession(chatID, "bug") {
+		t.Fatalf("expected duplicate name to fail")
+	}
+	if got := sm.GetSession(chatID).Name; got != "bug" {
+		t.Fatalf("active session = %q, want bug", got)
+	}
+
+	if !sm.SwitchSession(chatID, "reading") {
+		t.Fatalf("expected SwitchSession to succeed")
+	}
+	sm.AppendBlock(chatID, TextBlock{})
+
+	sessions := sm.Sessions(chatID)
+	if len(sessions) != 2 || sessions[0].Name != "bug" || len(sessions[1].Blocks) != 2 {
+		t.Fatalf("unexpected sessions: %+v", sessions)
+	}
+
+	ended, ok := sm.EndNamedSession(chatID, "reading")
+	if !ok || len(ended.Blocks) != 2 {
+		t.Fatalf("expected to end reading with 2 blocks")
+	}
+	if got := sm.GetSession(chatID); got == nil || got.Name != "bug" {
+		t.Fatalf("expected bug to become active, got %+v", got)
+	}
+	if sm.SwitchSession(chatID, "reading") {
+		t.Fatalf("expected switch to ended session to fail")
+	}
+}
+
+func TestStateMachineReplaceAndRemoveMessage(t *testing.T) {
+	sm := NewStateMachine()
+	chatID := int64(5)
+
+	sm.StartNamedSession(chatID, "notes")
+	sm.AppendBlock(chatID, CodeBlock{Content: "a", MessageID: "1"})
+	sm.AppendBlock(chatID, CodeBlock{Content: "b", MessageID: "2"})
+	sm.AppendBlock(chatID, ImageBlock{FileURL: "x", MessageID: "2"})
+	sm.AppendBlock(chatID, CodeBlock{Content: "c", MessageID: "3"})
+	sm.StartNamedSession(chatID, "other")
+
+	if !sm.ReplaceMessage(chatID, "2", []Block{CodeBlock{Content: "b2", MessageID: "2"}}) {
+		t.Fatalf("expected edit to find message in inactive session")
+	}
+	if sm.ReplaceMessage(chatID, "9", nil) {
+		t.Fatalf("expected unknown message to be ignored")
+	}
+	if !sm.RemoveMessage(chatID, "1") {
+		t.Fatalf("expected RemoveMessage to succeed")
+	}
+
+	sm.SwitchSession(chatID, "notes")
+	blocks := sm.GetSession(chatID).Blocks
+	if len(blocks) != 2 {
+		t.Fatalf("expected 2 blocks, got %d", len(blocks))
+	}
+	if code := blocks[0].(CodeBlock); code.Content != "b2" {
+		t.Fatalf("blocks[0] = %q, want b2", code.Content)
+	}
+	if code := blocks[1].(CodeBlock); code.Content != "c" {
+		t.Fatalf("blocks[1] = %q, want c", code.Content)
+	}
+}
+
+func TestStateMachineSessionsAreSnapshots(t *testing.T) {
+	sm := NewStateMachine()
+	sm.StartSession(1)
+
+	sessions := sm.Sessions(1)
+	sm.AppendBlock(1, CodeBlock{Content: "x"})
+	if len(sessions[0].Blocks) != 0 {
+		t.Fatalf("expected the listed session to be unaffected, got %d blocks", len(sessions[0].Blocks))
+	}
+}
@@ -0,0 +1,416 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"go.uber.org/zap"
+)
+
+// Store persists buffered sessions so they survive restarts.
+type Store interface {
+	Load() ([]*Session, error)
+	Save(session *Session) error
+	Delete(key Key) error
+	Close() error
+}
+
+// openStateMachine returns an in-memory state machine unless session.store_dir
+// is configured, in which case sessions are journaled to <store_dir>/<name>.jsonl.
+func openStateMachine(cfg *config.Config, name string, logger *zap.Logger) (*StateMachine, error) {
+	if cfg.Session.StoreDir == "" {
+		return NewStateMachine(), nil
+	}
+
+	store, err := OpenFileStore(filepath.Join(cfg.Session.StoreDir, name+".jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("open session store: %w", err)
+	}
+
+	sm, err := NewPersistentStateMachine(store, logger)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return sm, nil
+}
+
+type storedBlock struct {
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data"`
+}
+
+type storedSession struct {
+	ChatID       int64         `json:"chat_id"`
+	Name         string        `json:"name,omitempty"`
+	Blocks       []storedBlock `json:"blocks"`
+	UpdatedAt    time.Time     `json:"updated_at,omitempty"`
+	Reminded     bool          `json:"reminded,omitempty"`
+	AppendTo     string        `json:"append_to,omitempty"`
+	JournalTitle string        `json:"journal_title,omitempty"`
+	Written      int           `json:"written,omitempty"`
+	CreatedTitle string        `json:"created_title,omitempty"`
+}
+
+type journalRecord struct {
+	ChatID  int64          `json:"chat_id"`
+	Name    string         `json:"name,omitempty"`
+	Deleted bool           `json:"deleted,omitempty"`
+	Session *storedSession `json:"session,omitempty"`
+}
+
+// journalCompactBytes is the size past which the journal is rewritten once
+// most of it is superseded snapshots.
+var journalCompactBytes int64 = 1 << 20
+
+// FileStore keeps sessions in an append-only JSON lines journal. Each change
+// appends a full snapshot of the session. The journal is compacted on open,
+// and while running once it outgrows journalCompactBytes and is mostly
+// superseded snapshots.
+type FileStore struct {
+	mu       sync.Mutex
+	path     string
+	file     *os.File
+	sessions []*Session
+
+	// live holds the latest line of every session, and order the sessions in
+	// first-seen order, so compaction does not have to read the journal back.
+	live      map[Key][]byte
+	order     []Key
+	liveBytes int64
+	size      int64
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	records, err := readJournal(path)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &FileStore{path: path, live: make(map[Key][]byte)}
+	store.sessions = make([]*Session, 0, len(records))
+	for _, record := range records {
+		session, err := decodeSession(record.Session)
+		if err != nil {
+			return nil, fmt.Errorf("decode session %d: %w", record.ChatID, err)
+		}
+		store.sessions = append(store.sessions, session)
+
+		line, err := json.Marshal(record)
+		if err != nil {
+			return nil, err
+		}
+		store.track(Key{ChatID: record.ChatID, Name: record.Name}, append(line, '\n'), false)
+	}
+
+	if err := store.compact(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (s *FileStore) Load() ([]*Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.sessions, nil
+}
+
+func (s *FileStore) Save(session *Session) error {
+	stored, err := encodeSession(session)
+	if err != nil {
+		return err
+	}
+	return s.append(journalRecord{ChatID: session.ChatID, Name: session.Name, Session: stored})
+}
+
+func (s *FileStore) Delete(key Key) error {
+	return s.append(journalRecord{ChatID: key.ChatID, Name: key.Name, Deleted: true})
+}
+
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileStore) append(record journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return fmt.Errorf("session store is closed")
+	}
+	if _, err := s.file.Write(line); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+
+	s.size += int64(len(line))
+	s.track(Key{ChatID: record.ChatID, Name: record.Name}, line, record.Deleted)
+	if s.size > journalCompactBytes && s.size > 2*s.liveBytes {
+		if err := s.compact(); err != nil {
+			return fmt.Errorf("compact session journal: %w", err)
+		}
+	}
+	return nil
+}
+
+// track records line as the latest state of key.
+func (s *FileStore) track(key Key, line []byte, deleted bool) {
+	if previous, ok := s.live[key]; ok {
+		s.liveBytes -= int64(len(previous))
+		delete(s.live, key)
+	} else if !deleted {
+		s.order = append(s.order, key)
+	}
+	if deleted {
+		return
+	}
+	s.live[key] = line
+	s.liveBytes += int64(len(line))
+}
+
+// compact rewrites the journal with only the latest line of every session
+// and reopens it for appending.
+func (s *FileStore) compact() error {
+	var buf bytes.Buffer
+	order := make([]Key, 0, len(s.live))
+	seen := make(map[Key]bool, len(s.live))
+	for _, key := range s.order {
+		line, ok := s.live[key]
+		// A session deleted and started again appears twice in order.
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		buf.Write(line)
+		order = append(order, key)
+	}
+	if err := writeFileAtomic(s.path, buf.Bytes()); err != nil {
+		return err
+	}
+
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	s.file = file
+	if err != nil {
+		return err
+	}
+	s.order = order
+	s.size = int64(buf.Len())
+	return nil
+}
+
+// readJournal replays the journal and returns the latest record of every live
+// session in first-seen order. A torn trailing line from a crash is ignored;
+// an unreadable line anywhere else is reported as corruption.
+func readJournal(path string) ([]journalRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	order := make([]Key, 0)
+	latest := make(map[Key]journalRecord)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	number := 0
+	var torn error
+	for scanner.Scan() {
+		number++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if torn != nil {
+			return nil, torn
+		}
+
+		var record journalRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			torn = fmt.Errorf("session journal %s is corrupt at line %d: %w", path, number, err)
+			continue
+		}
+
+		key := Key{ChatID: record.ChatID, Name: record.Name}
+		if record.Deleted || record.Session == nil {
+			delete(latest, key)
+			continue
+		}
+		if _, exists := latest[key]; !exists {
+			order = append(order, key)
+		}
+		latest[key] = record
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	records := make([]journalRecord, 0, len(latest))
+	for _, key := range order {
+		if record, ok := latest[key]; ok {
+			records = append(records, record)
+			delete(latest, key)
+		}
+	}
+	return records, nil
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	if err := os.Chmod(tmpName, 0o600); err != nil {
+		_ = os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
+
+func encodeSession(session *Session) (*storedSession, error) {
+	stored := &storedSession{
+		ChatID:       session.ChatID,
+		Name:         session.Name,
+		Blocks:       make([]storedBlock, 0, len(session.Blocks)),
+		UpdatedAt:    session.UpdatedAt,
+		Reminded:     session.Reminded,
+		AppendTo:     session.AppendTo,
+		JournalTitle: session.JournalTitle,
+		Written:      session.Written,
+		CreatedTitle: session.CreatedTitle,
+	}
+	for _, block := range session.Blocks {
+		encoded, err := encodeBlock(block)
+		if err != nil {
+			return nil, err
+		}
+		stored.Blocks = append(stored.Blocks, encoded)
+	}
+	return stored, nil
+}
+
+func decodeSession(stored *storedSession) (*Session, error) {
+	session := &Session{
+		ChatID:       stored.ChatID,
+		Name:         stored.Name,
+		Blocks:       make([]Block, 0, len(stored.Blocks)),
+		UpdatedAt:    stored.UpdatedAt,
+		Reminded:     stored.Reminded,
+		AppendTo:     stored.AppendTo,
+		JournalTitle: stored.JournalTitle,
+		Written:      stored.Written,
+		CreatedTitle: stored.CreatedTitle,
+	}
+	for _, encoded := range stored.Blocks {
+		block, err := decodeBlock(encoded)
+		if err != nil {
+			return nil, err
+		}
+		session.Blocks = append(session.Blocks, block)
+	}
+	return session, nil
+}
+
+func encodeBlock(block Block) (storedBlock, error) {
+	data, err := json.Marshal(block)
+	if err != nil {
+		return storedBlock{}, err
+	}
+	return storedBlock{Kind: block.Kind(), Data: data}, nil
+}
+
+func decodeBlock(encoded storedBlock) (Block, error) {
+	switch encoded.Kind {
+	case "text":
+		var block TextBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	case "quote":
+		var block QuoteBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	case "code":
+		var block CodeBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	case "image":
+		var block ImageBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	case "file":
+		var block FileBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	case "sticker":
+		var block StickerBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	case "location":
+		var block LocationBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	case "contact":
+		var block ContactBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	case "poll":
+		var block PollBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	case "source":
+		var block SourceBlock
+		err := json.Unmarshal(encoded.Data, &block)
+		return block, err
+	default:
+		return nil, fmt.Errorf("unknown block kind %q", encoded.Kind)
+	}
+}
@@ -0,0 +1,218 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jomei/notionapi"
+)
+
+func TestFileStoreRestoresSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telegram.jsonl")
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	sm, err := NewPersistentStateMachine(store, nil)
+	if err != nil {
+		t.Fatalf("NewPersistentStateMachine() error = %v", err)
+	}
+
+	sm.StartSession(1)
+	sm.AppendBlock(1, TextBlock{RichText: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: "hello"}}}})
+	sm.AppendBlock(1, CodeBlock{Content: "fmt.Println()", Language: "go"})
+	sm.AppendBlock(1, ImageBlock{FileID: "file-1", Caption: "cat"})
+	sm.StartSession(2)
+	sm.DiscardSession(2)
+	if err := sm.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	store, err = OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	restored, err := NewPersistentStateMachine(store, nil)
+	if err != nil {
+		t.Fatalf("NewPersistentStateMachine() error = %v", err)
+	}
+	defer restored.Close()
+
+	if restored.IsActive(2) {
+		t.Fatalf("expected discarded session to stay gone")
+	}
+	session := restored.GetSession(1)
+	if session == nil || len(session.Blocks) != 3 {
+		t.Fatalf("expected 3 restored blocks, got %+v", session)
+	}
+	text, ok := session.Blocks[0].(TextBlock)
+	if !ok || text.RichText[0].Text.Content != "hello" {
+		t.Fatalf("unexpected text block: %#v", session.Blocks[0])
+	}
+	if code, ok := session.Blocks[1].(CodeBlock); !ok || code.Language != "go" {
+		t.Fatalf("unexpected code block: %#v", session.Blocks[1])
+	}
+	if image, ok := session.Blocks[2].(ImageBlock); !ok || image.FileID != "file-1" || image.Caption != "cat" {
+		t.Fatalf("unexpected image block: %#v", session.Blocks[2])
+	}
+}
+
+func TestFileStoreIgnoresTornLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "discord.jsonl")
+	content := `{"chat_id":7,"session":{"chat_id":7,"blocks":[{"kind":"code","data":{"content":"x"}}]}}` + "\n" + `{"chat_id":7,"sess`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	defer store.Close()
+
+	sessions, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(sessions) != 1 || len(sessions[0].Blocks) != 1 {
+		t.Fatalf("expected one restored session, got %+v", sessions)
+	}
+}
+
+func TestFileStoreRejectsCorruptLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "discord.jsonl")
+	record := `{"chat_id":7,"session":{"chat_id":7,"blocks":[{"kind":"code","data":{"content":"x"}}]}}`
+	if err := os.WriteFile(path, []byte(record+"\n{garbage\n"+record+"\n"), 0o600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+
+	if _, err := OpenFileStore(path); err == nil || !strings.Contains(err.Error(), "corrupt at line 2") {
+		t.Fatalf("expected a corruption error, got %v", err)
+	}
+}
+
+func TestFileStoreCompactsWhileRunning(t *testing.T) {
+	previous := journalCompactBytes
+	journalCompactBytes = 1024
+	t.Cleanup(func() { journalCompactBytes = previous })
+
+	path := filepath.Join(t.TempDir(), "telegram.jsonl")
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	sm, err := NewPersistentStateMachine(store, nil)
+	if err != nil {
+		t.Fatalf("NewPersistentStateMachine() error = %v", err)
+	}
+	defer sm.Close()
+
+	sm.StartSession(1)
+	sm.StartSession(2)
+	for i := 0; i < 100; i++ {
+		sm.AppendBlock(1, CodeBlock{Content: "line"})
+	}
+	records, err := readJournal(path)
+	if err != nil {
+		t.Fatalf("readJournal() error = %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected two live sessions, got %d", len(records))
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > 5 {
+		t.Fatalf("expected the journal to be compacted, got %d lines", lines)
+	}
+
+	sm.DiscardSession(1)
+	sm.AppendBlock(2, CodeBlock{Content: "x"})
+	if info, err := os.Stat(path); err != nil || info.Size() > journalCompactBytes {
+		t.Fatalf("expected the discarded session to be compacted away, got %v, %v", info.Size(), err)
+	}
+
+	reopened, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("reopen error = %v", err)
+	}
+	defer reopened.Close()
+	sessions, _ := reopened.Load()
+	if len(sessions) != 1 || sessions[0].ChatID != 2 || len(sessions[0].Blocks) != 1 {
+		t.Fatalf("unexpected sessions after compaction %+v", sessions)
+	}
+}
+
+func TestEncodeSessionKeepsTarget(t *testing.T) {
+	stored, err := encodeSession(&Session{ChatID: 1, Name: DefaultSessionName, AppendTo: "page-1", JournalTitle: "2026-01-02", Written: 100, CreatedTitle: "Notes"})
+	if err != nil {
+		t.Fatalf("encodeSession() error = %v", err)
+	}
+	session, err := decodeSession(stored)
+	if err != nil {
+		t.Fatalf("decodeSession() error = %v", err)
+	}
+	if session.AppendTo != "page-1" || session.JournalTitle != "2026-01-02" || session.Written != 100 || session.CreatedTitle != "Notes" {
+		t.Fatalf("unexpected target: %+v", session)
+	}
+}
+
+func TestFileStoreMigratesUnnamedSessions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "telegram.jsonl")
+
+	store, err := OpenFileStore(path)
+	if err != nil {
+		t.Fatalf("OpenFileStore() error = %v", err)
+	}
+	legacy := &Session{ChatID: 1, Blocks: []Block{CodeBlock{Content: "old"}}}
+	if err := store.Save(legacy); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	open := func() *StateMachine {
+		t.Helper()
+		store, err := OpenFileStore(path)
+		if err != nil {
+			t.Fatalf("OpenFileStore() error = %v", err)
+		}
+		sm, err := NewPersistentStateMachine(store, nil)
+		if err != nil {
+			t.Fatalf("NewPersistentStateMachine() error = %v", err)
+		}
+		return sm
+	}
+
+	sm := open()
+	sm.AppendBlock(1, CodeBlock{Content: "new"})
+	if err := sm.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	for restart := 1; restart <= 2; restart++ {
+		sm := open()
+		sessions := sm.Sessions(1)
+		if len(sessions) != 1 || sessions[0].Name != DefaultSessionName || len(sessions[0].Blocks) != 2 {
+			t.Fatalf("restart %d: expected one default session with 2 blocks, got %+v", restart, sessions)
+		}
+		if err := sm.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+
+	records, err := readJournal(path)
+	if err != nil {
+		t.Fatalf("readJournal() error = %v", err)
+	}
+	for _, record := range records {
+		if record.Name == "" {
+			t.Fatalf("expected the unnamed record to be dropped, got %+v", record)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package session
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jomei/notionapi"
+)
+
+var contactEmoji = notionapi.Emoji("📇")
+
+// mapURL links to the coordinates of a location on Google Maps.
+func mapURL(b LocationBlock) string {
+	return "https://www.google.com/maps/search/?api=1&query=" + coordinates(b)
+}
+
+func coordinates(b LocationBlock) string {
+	return strconv.FormatFloat(b.Latitude, 'f', 6, 64) + "," + strconv.FormatFloat(b.Longitude, 'f', 6, 64)
+}
+
+// locationBlock bookmarks the map of a location, captioned with the venue
+// name and address or the bare coordinates.
+func locationBlock(b LocationBlock) notionapi.Block {
+	caption := coordinates(b)
+	if b.Title != "" {
+		caption = b.Title
+		if b.Address != "" {
+			caption += " — " + b.Address
+		}
+	}
+
+	return &notionapi.BookmarkBlock{
+		BasicBlock: notionapi.BasicBlock{Object: "block", Type: "bookmark"},
+		Bookmark: notionapi.Bookmark{
+			URL:     mapURL(b),
+			Caption: []notionapi.RichText{{Type: "text", Text: &notionapi.Text{Content: caption}}},
+		},
+	}
+}
+
+func contactName(b ContactBlock) string {
+	return strings.TrimSpace(b.FirstName + " " + b.LastName)
+}
+
+// contactBlock shows a contact card as a callout with the name and number.
+func contactBlock(b ContactBlock) notionapi.Block {
+	richText := []notionapi.RichText{
+		{Type: "text", Text: &notionapi.Text{Content: contactName(b)}, Annotations: &notionapi.Annotations{Bold: true}},
+	}
+	if b.PhoneNumber != "" {
+		richText = append(richText, notionapi.RichText{Type: "text", Text: &notionapi.Text{Content: "\n" + b.PhoneNumber}})
+	}
+
+	return &notionapi.CalloutBlock{
+		BasicBlock: notionapi.BasicBlock{Object: "block", Type: "callout"},
+		Callout: notionapi.Callout{
+			RichText: richText,
+			Icon:     &notionapi.Icon{Type: "emoji", Emoji: &contactEmoji},
+		},
+	}
+}
+
+// pollBlocks renders the question followed by one bullet per option with
+// its share of the votes.
+func pollBlocks(b PollBlock) []notionapi.Block {
+	summary := fmt.Sprintf(" · %d votes", b.TotalVotes)
+	if b.TotalVotes == 1 {
+		summary = " · 1 vote"
+	}
+	if b.Closed {
+		summary += " (closed)"
+	}
+
+	blocks := make([]notionapi.Block, 0, len(b.Options)+1)
+	blocks = append(blocks, &notionapi.ParagraphBlock{
+		BasicBlock: notionapi.BasicBlock{Object: "block", Type: "paragraph"},
+		Paragraph: notionapi.Paragraph{RichText: []notionapi.RichText{
+			{Type: "text", Text: &notionapi.Text{Content: "📊 " + b.Question}, Annotations: &notionapi.Annotations{Bold: true}},
+			{Type: "text", Text: &notionapi.Text{Content: summary}},
+		}},
+	})
+
+	for _, option := range b.Options {
+		share := 0
+		if b.TotalVotes > 0 {
+			share = option.Votes * 100 / b.TotalVotes
+		}
+		blocks = append(blocks, &notionapi.BulletedListItemBlock{
+			BasicBlock: notionapi.BasicBlock{Object: "block", Type: "bulleted_list_item"},
+			BulletedListItem: notionapi.ListItem{RichText: []notionapi.RichText{
+				{Type: "text", Text: &notionapi.Text{Content: option.Text}},
+				{Type: "text", Text: &notionapi.Text{Content: fmt.Sprintf(" — %d (%d%%)", option.Votes, share)}, Annotations: &notionapi.Annotations{Color: notionapi.ColorGray}},
+			}},
+		})
+	}
+	return blocks
+}
@@ -0,0 +1,42 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/jomei/notionapi"
+)
+
+func TestLocationBlock(t *testing.T) {
+	bookmark, ok := locationBlock(LocationBlock{Latitude: 52.52, Longitude: 13.405, Title: "Cafe", Address: "Main St"}).(*notionapi.BookmarkBlock)
+	if !ok {
+		t.Fatal("expected bookmark block")
+	}
+	if bookmark.Bookmark.URL != "https://www.google.com/maps/search/?api=1&query=52.520000,13.405000" {
+		t.Fatalf("unexpected map url %q", bookmark.Bookmark.URL)
+	}
+	if plainText(bookmark.Bookmark.Caption) != "Cafe — Main St" {
+		t.Fatalf("unexpected caption %q", plainText(bookmark.Bookmark.Caption))
+	}
+}
+
+func TestPollBlocks(t *testing.T) {
+	blocks := pollBlocks(PollBlock{
+		Question:   "Lunch?",
+		TotalVotes: 4,
+		Options:    []PollOption{{Text: "Pizza", Votes: 3}, {Text: "Salad", Votes: 1}},
+	})
+	if len(blocks) != 3 {
+		t.Fatalf("expected question and 2 options, got %d blocks", len(blocks))
+	}
+	option, ok := blocks[1].(*notionapi.BulletedListItemBlock)
+	if !ok || plainText(option.BulletedListItem.RichText) != "Pizza — 3 (75%)" {
+		t.Fatalf("unexpected option block: %#v", blocks[1])
+	}
+}
+
+func TestContactBlock(t *testing.T) {
+	callout, ok := contactBlock(ContactBlock{FirstName: "Ada", LastName: "Lovelace", PhoneNumber: "+100"}).(*notionapi.CalloutBlock)
+	if !ok || plainText(callout.Callout.RichText) != "Ada Lovelace\n+100" {
+		t.Fatalf("unexpected contact block: %#v", callout)
+	}
+}
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"go.uber.org/zap"
+)
+
+const idleReminderMessage = "Session %[1]q has been idle for a while. Use /end %[1]s to save or /discard %[1]s to drop it."
+
+// IdleSweeper periodically saves, discards or flags sessions that have seen
+// no activity for longer than session.idle_timeout.
+type IdleSweeper struct {
+	stateMachine *StateMachine
+	pipeline     *Pipeline
+	timeout      time.Duration
+	action       string
+	logger       *zap.Logger
+	now          func() time.Time
+}
+
+func NewIdleSweeper(cfg config.Session, stateMachine *StateMachine, pipeline *Pipeline, logger *zap.Logger) *IdleSweeper {
+	return &IdleSweeper{
+		stateMachine: stateMachine,
+		pipeline:     pipeline,
+		timeout:      cfg.IdleTimeout,
+		action:       cfg.IdleAction,
+		logger:       logger,
+		now:          time.Now,
+	}
+}
+
+// Run sweeps until ctx is cancelled. It returns immediately when no idle
+// timeout is configured.
+func (s *IdleSweeper) Run(ctx context.Context) {
+	if s.timeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(sweepInterval(s.timeout))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Sweep(ctx)
+		}
+	}
+}
+
+// Sweep applies the idle action to every session idle at the current time.
+func (s *IdleSweeper) Sweep(ctx context.Context) {
+	cutoff := s.now().Add(-s.timeout)
+	notify := s.pipeline.platform.Notify
+	chatField := s.pipeline.platform.ChatField
+
+	for _, key := range s.stateMachine.IdleSessions(cutoff) {
+		if ctx.Err() != nil {
+			return
+		}
+
+		switch s.action {
+		case config.IdleActionDiscard:
+			if _, ok := s.stateMachine.EndIdleSession(key, cutoff); ok {
+				notify(key.ChatID, fmt.Sprintf("Idle session %q discarded.", key.Name))
+				if s.logger != nil {
+					s.logger.Info("idle session discarded", chatField(key.ChatID), zap.String("session", key.Name))
+				}
+			}
+		case config.IdleActionRemind:
+			if s.stateMachine.MarkReminded(key, cutoff) {
+				notify(key.ChatID, fmt.Sprintf(idleReminderMessage, key.Name))
+				if s.logger != nil {
+					s.logger.Info("idle session reminded", chatField(key.ChatID), zap.String("session", key.Name))
+				}
+			}
+		default:
+			if session, ok := s.stateMachine.EndIdleSession(key, cutoff); ok {
+				notify(key.ChatID, fmt.Sprintf("Idle session %q closed. %s", key.Name, s.pipeline.Finish(ctx, session)))
+			}
+		}
+	}
+}
+
+// sweepInterval checks often enough to act within a tenth of the timeout,
+// but never more than once a second or less than once a minute.
+func sweepInterval(timeout time.Duration) time.Duration {
+	interval := timeout / 10
+	if interval < time.Second {
+		return time.Second
+	}
+	if interval > time.Minute {
+		return time.Minute
+	}
+	return interval
+}
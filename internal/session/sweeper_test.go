@@ -0,0 +1,62 @@
+package session
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+)
+
+func TestIdleSweeperDiscard(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	sm := NewStateMachine()
+	sm.now = func() time.Time { return now }
+	sm.StartSession(1)
+	sm.AppendBlock(1, CodeBlock{Content: "x"})
+
+	platform := &fakePlatform{}
+	sweeper := NewIdleSweeper(config.Session{IdleTimeout: time.Hour, IdleAction: config.IdleActionDiscard}, sm, NewPipeline(nil, platform, nil, nil, nil, nil, nil), nil)
+
+	sweeper.now = func() time.Time { return now.Add(30 * time.Minute) }
+	sweeper.Sweep(context.Background())
+	if !sm.IsActive(1) {
+		t.Fatalf("expected session to survive before timeout")
+	}
+
+	sweeper.now = func() time.Time { return now.Add(2 * time.Hour) }
+	sweeper.Sweep(context.Background())
+	if sm.IsActive(1) {
+		t.Fatalf("expected idle session to be discarded")
+	}
+	if len(platform.notices) != 1 {
+		t.Fatalf("expected one notice, got %v", platform.notices)
+	}
+}
+
+func TestIdleSweeperRemindOnce(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	sm := NewStateMachine()
+	sm.now = func() time.Time { return now }
+	sm.StartSession(1)
+
+	platform := &fakePlatform{}
+	sweeper := NewIdleSweeper(config.Session{IdleTimeout: time.Hour, IdleAction: config.IdleActionRemind}, sm, NewPipeline(nil, platform, nil, nil, nil, nil, nil), nil)
+	sweeper.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	sweeper.Sweep(context.Background())
+	sweeper.Sweep(context.Background())
+	if !sm.IsActive(1) {
+		t.Fatalf("expected reminded session to stay active")
+	}
+	if len(platform.notices) != 1 || platform.notices[0] != fmt.Sprintf(idleReminderMessage, DefaultSessionName) {
+		t.Fatalf("expected a single reminder, got %v", platform.notices)
+	}
+
+	sm.now = func() time.Time { return now.Add(2 * time.Hour) }
+	sm.AppendBlock(1, CodeBlock{Content: "x"})
+	if sm.GetSession(1).Reminded {
+		t.Fatalf("expected activity to reset the reminder")
+	}
+}
@@ -0,0 +1,156 @@
+package session
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/nerdneilsfield/telenotion-bot/internal/tgclient"
+	"go.uber.org/zap"
+)
+
+const (
+	webhookSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+	// webhookQueueSize bounds the updates accepted but not yet handled. When
+	// it is full the webhook answers 503 and Telegram redelivers later.
+	webhookQueueSize = 100
+	webhookMaxBody   = 1 << 20
+	// webhookDrainTimeout bounds how long queued updates are still handled
+	// after the webhook stops.
+	webhookDrainTimeout = 30 * time.Second
+)
+
+// serveWebhook registers the webhook and handles updates delivered to it until
+// ctx is cancelled. Updates are acknowledged immediately and handled one at a
+// time in arrival order; on shutdown the queue is drained before returning.
+func (r *Runner) serveWebhook(ctx context.Context) error {
+	webhookURL, err := url.Parse(r.cfg.Telegram.WebhookURL)
+	if err != nil {
+		return err
+	}
+	path := webhookURL.Path
+	if path == "" {
+		path = "/"
+	}
+
+	listener, err := net.Listen("tcp", r.cfg.Telegram.WebhookListen)
+	if err != nil {
+		return err
+	}
+
+	if err := r.telegram.SetWebhook(webhookURL.String(), r.cfg.Telegram.WebhookSecret); err != nil {
+		listener.Close()
+		return err
+	}
+	if r.logger != nil {
+		r.logger.Info("telegram webhook registered", zap.String("listen", listener.Addr().String()), zap.String("path", path))
+	}
+
+	updates := make(chan tgclient.Update, webhookQueueSize)
+	mux := http.NewServeMux()
+	mux.Handle(path, r.webhookHandler(updates))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	// Updates are handled on a context of their own so the ones already
+	// acknowledged still run after ctx is cancelled, within the drain timeout.
+	handleCtx, stopHandling := context.WithCancel(context.WithoutCancel(ctx))
+	defer stopHandling()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.handleQueued(handleCtx, updates, stop)
+	}()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	select {
+	case err = <-serveErr:
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if shutdownErr := server.Shutdown(shutdownCtx); shutdownErr != nil && !errors.Is(shutdownErr, http.ErrServerClosed) && err == nil {
+		err = shutdownErr
+	}
+
+	close(stop)
+	drain := time.NewTimer(webhookDrainTimeout)
+	defer drain.Stop()
+	select {
+	case <-done:
+	case <-drain.C:
+		if r.logger != nil {
+			r.logger.Warn("gave up draining webhook updates", zap.Int("pending", len(updates)))
+		}
+		stopHandling()
+		<-done
+	}
+	return err
+}
+
+// handleQueued dispatches updates until stop is closed and then handles the
+// ones still queued, since Telegram will not deliver acknowledged updates
+// again.
+func (r *Runner) handleQueued(ctx context.Context, updates <-chan tgclient.Update, stop <-chan struct{}) {
+	for {
+		select {
+		case update := <-updates:
+			r.dispatch(ctx, update)
+			continue
+		case <-stop:
+		}
+
+		for {
+			select {
+			case update := <-updates:
+				r.dispatch(ctx, update)
+			default:
+				return
+			}
+		}
+	}
+}
+
+// webhookHandler accepts updates that carry the configured secret token and
+// queues them on updates.
+func (r *Runner) webhookHandler(updates chan<- tgclient.Update) http.Handler {
+	secret := []byte(r.cfg.Telegram.WebhookSecret)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get(webhookSecretHeader)), secret) != 1 {
+			if r.logger != nil {
+				r.logger.Warn("rejected webhook request with invalid secret token", zap.String("remote", req.RemoteAddr))
+			}
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		var update tgclient.Update
+		if err := json.NewDecoder(http.MaxBytesReader(w, req.Body, webhookMaxBody)).Decode(&update); err != nil {
+			http.Error(w, "invalid update", http.StatusBadRequest)
+			return
+		}
+
+		select {
+		case updates <- update:
+			w.WriteHeader(http.StatusOK)
+		default:
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+		}
+	})
+}
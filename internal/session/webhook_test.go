@@ -0,0 +1,78 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/nerdneilsfield/telenotion-bot/internal/config"
+	"github.com/nerdneilsfield/telenotion-bot/internal/tgclient"
+)
+
+func TestWebhookHandlerVerifiesSecret(t *testing.T) {
+	r := &Runner{cfg: &config.Config{Telegram: config.Telegram{WebhookSecret: "s3cret"}}}
+	updates := make(chan tgclient.Update, 1)
+	handler := r.webhookHandler(updates)
+
+	tests := []struct {
+		name   string
+		method string
+		secret string
+		body   string
+		want   int
+	}{
+		{name: "missing secret", method: http.MethodPost, body: `{"update_id":1}`, want: http.StatusForbidden},
+		{name: "wrong secret", method: http.MethodPost, secret: "guess", body: `{"update_id":1}`, want: http.StatusForbidden},
+		{name: "wrong method", method: http.MethodGet, secret: "s3cret", want: http.StatusMethodNotAllowed},
+		{name: "invalid body", method: http.MethodPost, secret: "s3cret", body: `{`, want: http.StatusBadRequest},
+		{name: "accepted", method: http.MethodPost, secret: "s3cret", body: `{"update_id":42}`, want: http.StatusOK},
+		{name: "queue full", method: http.MethodPost, secret: "s3cret", body: `{"update_id":43}`, want: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/telegram", strings.NewReader(tt.body))
+			if tt.secret != "" {
+				req.Header.Set(webhookSecretHeader, tt.secret)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Fatalf("expected status %d, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+
+	if update := <-updates; update.UpdateID != 42 {
+		t.Fatalf("expected update 42 to be queued, got %d", update.UpdateID)
+	}
+}
+
+func TestHandleQueuedDrainsAfterStop(t *testing.T) {
+	r := &Runner{cfg: &config.Config{}}
+	updates := make(chan tgclient.Update, 3)
+	for id := 1; id <= 3; id++ {
+		updates <- tgclient.Update{Update: tgbotapi.Update{UpdateID: id}}
+	}
+	stop := make(chan struct{})
+	close(stop)
+
+	done := make(chan struct{})
+	go func() {
+		r.handleQueued(context.Background(), updates, stop)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("handleQueued did not return after stop")
+	}
+	if len(updates) != 0 {
+		t.Fatalf("expected queue to be drained, %d updates left", len(updates))
+	}
+}
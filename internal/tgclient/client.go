@@ -1,28 +1,50 @@
 package tgclient
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
 )
 
+// ErrFileTooBig is returned by GetFileURL when the Bot API refuses to hand out
+// a file over its download limit.
+var ErrFileTooBig = errors.New("file is too big for the bot api")
+
 type Client struct {
-	bot    *tgbotapi.BotAPI
-	client *http.Client
+	bot          *tgbotapi.BotAPI
+	client       *http.Client
+	fileEndpoint string
+	localMode    bool
 }
 
-func NewClient(token string) (*Client, error) {
-	bot, err := tgbotapi.NewBotAPI(token)
+// NewClient connects to the Bot API at apiEndpoint, or the public one when it
+// is empty. A server in local mode hands out file paths on its own disk,
+// which GetFileURL returns as file:// URLs.
+func NewClient(token string, apiEndpoint string, localMode bool) (*Client, error) {
+	endpoint, fileEndpoint := tgbotapi.APIEndpoint, tgbotapi.FileEndpoint
+	if apiEndpoint != "" {
+		base := strings.TrimSuffix(apiEndpoint, "/")
+		endpoint, fileEndpoint = base+"/bot%s/%s", base+"/file/bot%s/%s"
+	}
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(token, endpoint)
 	if err != nil {
 		return nil, err
 	}
 
 	return &Client{
-		bot:    bot,
-		client: newHTTPClient(),
+		bot:          bot,
+		client:       newHTTPClient(),
+		fileEndpoint: fileEndpoint,
+		localMode:    localMode,
 	}, nil
 }
 
@@ -43,18 +65,33 @@ func newHTTPClient() *http.Client {
 	}
 }
 
-func (c *Client) GetUpdates(offset int, timeout int) ([]tgbotapi.Update, error) {
-	u := tgbotapi.NewUpdate(timeout)
-	u.Offset = offset
+func (c *Client) GetUpdates(offset int, timeout int) ([]Update, error) {
+	params := tgbotapi.Params{}
+	params.AddNonZero("offset", offset)
+	params.AddNonZero("timeout", timeout)
 
-	updates, err := c.bot.GetUpdates(u)
+	resp, err := c.bot.MakeRequest("getUpdates", params)
 	if err != nil {
 		return nil, err
 	}
 
+	var updates []Update
+	if err := json.Unmarshal(resp.Result, &updates); err != nil {
+		return nil, err
+	}
 	return updates, nil
 }
 
+// Username is the bot's own username, without the "@".
+func (c *Client) Username() string {
+	return c.bot.Self.UserName
+}
+
+// UserID is the bot's own user ID.
+func (c *Client) UserID() int64 {
+	return c.bot.Self.ID
+}
+
 func (c *Client) IsAllowedChat(allowed []int64, chatID int64) bool {
 	for _, id := range allowed {
 		if id == chatID {
@@ -67,10 +104,17 @@ func (c *Client) IsAllowedChat(allowed []int64, chatID int64) bool {
 func (c *Client) GetFileURL(fileID string) (string, error) {
 	file, err := c.bot.GetFile(tgbotapi.FileConfig{FileID: fileID})
 	if err != nil {
+		var apiErr *tgbotapi.Error
+		if errors.As(err, &apiErr) && strings.Contains(strings.ToLower(apiErr.Message), "file is too big") {
+			return "", fmt.Errorf("%w: %v", ErrFileTooBig, err)
+		}
 		return "", err
 	}
 
-	return file.Link(c.bot.Token), nil
+	if c.localMode {
+		return (&url.URL{Scheme: "file", Path: file.FilePath}).String(), nil
+	}
+	return fmt.Sprintf(c.fileEndpoint, c.bot.Token, file.FilePath), nil
 }
 
 func (c *Client) DownloadFile(url string) ([]byte, error) {
@@ -89,8 +133,75 @@ func (c *Client) SendMessage(chatID int64, text string) error {
 	return err
 }
 
+// SendTopicMessage sends text to a forum topic of chatID.
+func (c *Client) SendTopicMessage(chatID int64, topic int, text string) error {
+	params := tgbotapi.Params{"text": text}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_thread_id", topic)
+	_, err := c.bot.MakeRequest("sendMessage", params)
+	return err
+}
+
+// SendKeyboard sends text with an inline keyboard to chatID, in a forum topic
+// when topic is set, and returns the ID of the sent message.
+func (c *Client) SendKeyboard(chatID int64, topic int, text string, keyboard tgbotapi.InlineKeyboardMarkup) (int, error) {
+	params := tgbotapi.Params{"text": text}
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_thread_id", topic)
+	if err := params.AddInterface("reply_markup", keyboard); err != nil {
+		return 0, err
+	}
+
+	resp, err := c.bot.MakeRequest("sendMessage", params)
+	if err != nil {
+		return 0, err
+	}
+	var sent tgbotapi.Message
+	if err := json.Unmarshal(resp.Result, &sent); err != nil {
+		return 0, err
+	}
+	return sent.MessageID, nil
+}
+
+// EditMessage replaces the text of a message the bot sent. A nil keyboard
+// removes any buttons it had.
+func (c *Client) EditMessage(chatID int64, messageID int, text string, keyboard *tgbotapi.InlineKeyboardMarkup) error {
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ReplyMarkup = keyboard
+	_, err := c.bot.Request(edit)
+	return err
+}
+
+// DeleteMessage removes a message from chatID.
+func (c *Client) DeleteMessage(chatID int64, messageID int) error {
+	_, err := c.bot.Request(tgbotapi.NewDeleteMessage(chatID, messageID))
+	return err
+}
+
+// AnswerCallback acknowledges a button press, showing text to the user when
+// it is not empty.
+func (c *Client) AnswerCallback(queryID string, text string) error {
+	_, err := c.bot.Request(tgbotapi.NewCallback(queryID, text))
+	return err
+}
+
 func (c *Client) SetCommands(commands []tgbotapi.BotCommand) error {
 	config := tgbotapi.NewSetMyCommands(commands...)
 	_, err := c.bot.Request(config)
 	return err
 }
+
+// SetWebhook points Telegram at url. Every update delivered there carries
+// secret in the X-Telegram-Bot-Api-Secret-Token header.
+func (c *Client) SetWebhook(url string, secret string) error {
+	params := tgbotapi.Params{"url": url}
+	params.AddNonEmpty("secret_token", secret)
+	_, err := c.bot.MakeRequest("setWebhook", params)
+	return err
+}
+
+// DeleteWebhook removes any webhook so updates can be polled again.
+func (c *Client) DeleteWebhook() error {
+	_, err := c.bot.Request(tgbotapi.DeleteWebhookConfig{})
+	return err
+}
@@ -0,0 +1,78 @@
+package tgclient
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newFakeBotAPI stands in for a Bot API server whose getFile reports
+// filePath, or refuses the file as too big when filePath is empty.
+func newFakeBotAPI(t *testing.T, filePath string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/bottest-token/getMe"):
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"id":42,"is_bot":true,"first_name":"Bot","username":"test_bot"}}`))
+		case strings.HasSuffix(r.URL.Path, "/bottest-token/getFile") && filePath == "":
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: file is too big"}`))
+		case strings.HasSuffix(r.URL.Path, "/bottest-token/getFile"):
+			_, _ = w.Write([]byte(`{"ok":true,"result":{"file_id":"f","file_path":"` + filePath + `"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNewClientCustomEndpoint(t *testing.T) {
+	server := newFakeBotAPI(t, "documents/file_1.pdf")
+
+	client, err := NewClient("test-token", server.URL+"/", false)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if client.Username() != "test_bot" || client.UserID() != 42 {
+		t.Fatalf("unexpected bot identity %q %d", client.Username(), client.UserID())
+	}
+
+	link, err := client.GetFileURL("f")
+	if err != nil {
+		t.Fatalf("GetFileURL() error = %v", err)
+	}
+	if want := server.URL + "/file/bottest-token/documents/file_1.pdf"; link != want {
+		t.Fatalf("GetFileURL() = %q, want %q", link, want)
+	}
+}
+
+func TestNewClientLocalMode(t *testing.T) {
+	server := newFakeBotAPI(t, "/var/lib/telegram-bot-api/test-token/documents/file_1.pdf")
+
+	client, err := NewClient("test-token", server.URL, true)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	link, err := client.GetFileURL("f")
+	if err != nil {
+		t.Fatalf("GetFileURL() error = %v", err)
+	}
+	if link != "file:///var/lib/telegram-bot-api/test-token/documents/file_1.pdf" {
+		t.Fatalf("GetFileURL() = %q", link)
+	}
+}
+
+func TestGetFileURLTooBig(t *testing.T) {
+	server := newFakeBotAPI(t, "")
+
+	client, err := NewClient("test-token", server.URL, false)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if _, err := client.GetFileURL("f"); !errors.Is(err, ErrFileTooBig) {
+		t.Fatalf("expected ErrFileTooBig, got %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package tgclient
 
 import (
 	"sort"
+	"strings"
+	"unicode/utf16"
 
 	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/jomei/notionapi"
@@ -13,6 +15,10 @@ func NewMapper() *Mapper {
 	return &Mapper{}
 }
 
+// EntitiesToRichText converts text and its entities into Notion rich text.
+// Entities may overlap or nest; the text is cut at every entity boundary and
+// each run carries the combined styles of all entities covering it. Offsets
+// are in UTF-16 code units, as Telegram sends them.
 func (m *Mapper) EntitiesToRichText(text string, entities []tgbotapi.MessageEntity) []notionapi.RichText {
 	if text == "" {
 		return nil
@@ -22,73 +28,171 @@ func (m *Mapper) EntitiesToRichText(text string, entities []tgbotapi.MessageEnti
 		return []notionapi.RichText{plainRichText(text)}
 	}
 
-	sorted := append([]tgbotapi.MessageEntity(nil), entities...)
-	sort.Slice(sorted, func(i, j int) bool {
-		return sorted[i].Offset < sorted[j].Offset
-	})
+	units := utf16.Encode([]rune(text))
+	spans := make([]span, 0, len(entities))
+	for _, entity := range entities {
+		if s, ok := newSpan(units, entity); ok {
+			spans = append(spans, s)
+		}
+	}
 
-	runes := []rune(text)
-	cursor := 0
-	result := make([]notionapi.RichText, 0, len(sorted)+1)
+	result := make([]notionapi.RichText, 0, 2*len(spans)+1)
+	for _, bounds := range boundaries(units, spans) {
+		start, end := bounds[0], bounds[1]
+		richText := plainRichText(decodeUnits(units, start, end))
+		for _, s := range spans {
+			if s.start <= start && s.end >= end {
+				applyEntity(&richText, s.entity, decodeUnits(units, s.start, s.end))
+			}
+		}
+		result = appendRun(result, richText)
+	}
 
-	for _, entity := range sorted {
-		start := entity.Offset
-		end := entity.Offset + entity.Length
+	return result
+}
 
-		if start < 0 || start >= len(runes) {
-			continue
-		}
-		if end > len(runes) {
-			end = len(runes)
-		}
+// span is an entity clamped to the text, in UTF-16 code units.
+type span struct {
+	start  int
+	end    int
+	entity tgbotapi.MessageEntity
+}
 
-		if start > cursor {
-			result = append(result, plainRichText(string(runes[cursor:start])))
-		}
+func newSpan(units []uint16, entity tgbotapi.MessageEntity) (span, bool) {
+	start := alignUnit(units, entity.Offset)
+	end := alignUnit(units, entity.Offset+entity.Length)
+	if start >= end {
+		return span{}, false
+	}
+	return span{start: start, end: end, entity: entity}, true
+}
 
-		segment := string(runes[start:end])
-		result = append(result, entityToRichText(segment, entity))
-		cursor = end
+// alignUnit clamps offset to the text and moves it off the middle of a
+// surrogate pair.
+func alignUnit(units []uint16, offset int) int {
+	if offset < 0 {
+		return 0
+	}
+	if offset >= len(units) {
+		return len(units)
 	}
+	if low := units[offset]; offset > 0 && low >= 0xdc00 && low < 0xe000 {
+		return offset - 1
+	}
+	return offset
+}
 
-	if cursor < len(runes) {
-		result = append(result, plainRichText(string(runes[cursor:])))
+// boundaries cuts the text at every span edge and returns the resulting
+// [start, end) runs in order.
+func boundaries(units []uint16, spans []span) [][2]int {
+	cuts := []int{0, len(units)}
+	for _, s := range spans {
+		cuts = append(cuts, s.start, s.end)
 	}
+	sort.Ints(cuts)
 
-	return result
+	runs := make([][2]int, 0, len(cuts))
+	for i := 1; i < len(cuts); i++ {
+		if cuts[i] > cuts[i-1] {
+			runs = append(runs, [2]int{cuts[i-1], cuts[i]})
+		}
+	}
+	return runs
 }
 
-func plainRichText(text string) notionapi.RichText {
-	return notionapi.RichText{
-		Type: "text",
-		Text: &notionapi.Text{Content: text},
+func decodeUnits(units []uint16, start int, end int) string {
+	return string(utf16.Decode(units[start:end]))
+}
+
+// appendRun adds richText, merging it into the previous run when both look
+// the same.
+func appendRun(result []notionapi.RichText, richText notionapi.RichText) []notionapi.RichText {
+	if len(result) > 0 {
+		last := &result[len(result)-1]
+		if sameStyle(*last, richText) {
+			last.Text.Content += richText.Text.Content
+			return result
+		}
 	}
+	return append(result, richText)
 }
 
-func entityToRichText(text string, entity tgbotapi.MessageEntity) notionapi.RichText {
-	richText := notionapi.RichText{
+func sameStyle(a notionapi.RichText, b notionapi.RichText) bool {
+	var aLink, bLink string
+	if a.Text.Link != nil {
+		aLink = a.Text.Link.Url
+	}
+	if b.Text.Link != nil {
+		bLink = b.Text.Link.Url
+	}
+	if aLink != bLink {
+		return false
+	}
+	if a.Annotations == nil || b.Annotations == nil {
+		return a.Annotations == nil && b.Annotations == nil
+	}
+	return *a.Annotations == *b.Annotations
+}
+
+func plainRichText(text string) notionapi.RichText {
+	return notionapi.RichText{
 		Type: "text",
 		Text: &notionapi.Text{Content: text},
 	}
+}
 
-	annotations := notionapi.Annotations{}
-
+// applyEntity adds the style of entity to richText. entityText is the full
+// text the entity covers, which links such as url and mention point at.
+func applyEntity(richText *notionapi.RichText, entity tgbotapi.MessageEntity, entityText string) {
 	switch entity.Type {
 	case "bold":
-		annotations.Bold = true
+		annotations(richText).Bold = true
 	case "italic":
-		annotations.Italic = true
-	case "code":
-		annotations.Code = true
+		annotations(richText).Italic = true
+	case "underline":
+		annotations(richText).Underline = true
+	case "strikethrough":
+		annotations(richText).Strikethrough = true
+	case "code", "pre":
+		annotations(richText).Code = true
+	case "spoiler":
+		// Notion has no spoiler style; a gray background marks the text
+		// instead.
+		annotations(richText).Color = notionapi.ColorGrayBackground
+	case "hashtag", "cashtag":
+		annotations(richText).Color = notionapi.ColorBlue
 	case "text_link":
-		if entity.URL != "" {
-			richText.Text.Link = &notionapi.Link{Url: entity.URL}
+		setLink(richText, entity.URL)
+	case "url":
+		link := strings.TrimSpace(entityText)
+		if !strings.Contains(link, "://") {
+			link = "https://" + link
+		}
+		setLink(richText, link)
+	case "email":
+		setLink(richText, "mailto:"+strings.TrimSpace(entityText))
+	case "mention":
+		setLink(richText, "https://t.me/"+strings.TrimPrefix(strings.TrimSpace(entityText), "@"))
+	case "text_mention":
+		if entity.User != nil && entity.User.UserName != "" {
+			setLink(richText, "https://t.me/"+entity.User.UserName)
 		}
 	}
+	// phone_number, custom_emoji and blockquote need no inline styling: the
+	// text already carries the number or fallback emoji, and quotes become
+	// their own blocks (see Sections).
+}
 
-	if annotations.Bold || annotations.Italic || annotations.Code {
-		richText.Annotations = &annotations
+func annotations(richText *notionapi.RichText) *notionapi.Annotations {
+	if richText.Annotations == nil {
+		richText.Annotations = &notionapi.Annotations{}
 	}
+	return richText.Annotations
+}
 
-	return richText
+func setLink(richText *notionapi.RichText, url string) {
+	if url == "" {
+		return
+	}
+	richText.Text.Link = &notionapi.Link{Url: url}
 }
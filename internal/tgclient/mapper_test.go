@@ -52,3 +52,138 @@ func TestEntitiesToRichTextPlain(t *testing.T) {
 		t.Fatalf("expected plain text")
 	}
 }
+
+func TestEntitiesToRichTextNested(t *testing.T) {
+	mapper := NewMapper()
+
+	// "bold italic link": bold covers everything, italic the middle word and
+	// a link the last word.
+	text := "bold italic link"
+	entities := []tgbotapi.MessageEntity{
+		{Type: "bold", Offset: 0, Length: 16},
+		{Type: "italic", Offset: 5, Length: 6},
+		{Type: "text_link", Offset: 12, Length: 4, URL: "https://example.com"},
+	}
+
+	result := mapper.EntitiesToRichText(text, entities)
+	want := []string{"bold ", "italic", " ", "link"}
+	if len(result) != len(want) {
+		t.Fatalf("expected %d runs, got %d", len(want), len(result))
+	}
+	for i, content := range want {
+		if result[i].Text.Content != content {
+			t.Fatalf("run %d: expected %q, got %q", i, content, result[i].Text.Content)
+		}
+		if result[i].Annotations == nil || !result[i].Annotations.Bold {
+			t.Fatalf("run %d: expected bold", i)
+		}
+	}
+	if !result[1].Annotations.Italic || result[0].Annotations.Italic {
+		t.Fatalf("expected only the middle word to be italic")
+	}
+	if result[3].Text.Link == nil || result[3].Text.Link.Url != "https://example.com" {
+		t.Fatalf("expected link on the last run")
+	}
+}
+
+func TestEntitiesToRichTextUTF16Offsets(t *testing.T) {
+	mapper := NewMapper()
+
+	// The emoji takes two UTF-16 code units, so "bold" starts at offset 3.
+	text := "😀 bold"
+	entities := []tgbotapi.MessageEntity{{Type: "bold", Offset: 3, Length: 4}}
+
+	result := mapper.EntitiesToRichText(text, entities)
+	if len(result) != 2 || result[0].Text.Content != "😀 " || result[1].Text.Content != "bold" {
+		t.Fatalf("unexpected runs: %+v", result)
+	}
+	if result[1].Annotations == nil || !result[1].Annotations.Bold {
+		t.Fatalf("expected bold run")
+	}
+}
+
+func TestEntitiesToRichTextLinks(t *testing.T) {
+	mapper := NewMapper()
+
+	text := "example.com @gopher me@example.com"
+	entities := []tgbotapi.MessageEntity{
+		{Type: "url", Offset: 0, Length: 11},
+		{Type: "mention", Offset: 12, Length: 7},
+		{Type: "email", Offset: 20, Length: 14},
+		{Type: "strikethrough", Offset: 20, Length: 2},
+	}
+
+	result := mapper.EntitiesToRichText(text, entities)
+	links := map[string]string{}
+	for _, run := range result {
+		if run.Text.Link != nil {
+			links[run.Text.Content] = run.Text.Link.Url
+		}
+	}
+	if links["example.com"] != "https://example.com" {
+		t.Fatalf("unexpected url link %q", links["example.com"])
+	}
+	if links["@gopher"] != "https://t.me/gopher" {
+		t.Fatalf("unexpected mention link %q", links["@gopher"])
+	}
+	if links["me"] != "mailto:me@example.com" || links["@example.com"] != "mailto:me@example.com" {
+		t.Fatalf("expected both email runs to link to the full address, got %v", links)
+	}
+}
+
+func TestSections(t *testing.T) {
+	text := "Look:\nquoted line\nthen\nfmt.Println()\nend"
+	entities := []tgbotapi.MessageEntity{
+		{Type: "blockquote", Offset: 6, Length: 12},
+		{Type: "bold", Offset: 6, Length: 6},
+		{Type: "pre", Offset: 23, Length: 14, Language: "go"},
+	}
+
+	sections := Sections(text, entities)
+	want := []Section{
+		{Kind: SectionText, Text: "Look:"},
+		{Kind: SectionQuote, Text: "quoted line"},
+		{Kind: SectionText, Text: "then"},
+		{Kind: SectionCode, Text: "fmt.Println()", Language: "go"},
+		{Kind: SectionText, Text: "end"},
+	}
+	if len(sections) != len(want) {
+		t.Fatalf("expected %d sections, got %+v", len(want), sections)
+	}
+	for i, section := range want {
+		if sections[i].Kind != section.Kind || sections[i].Text != section.Text || sections[i].Language != section.Language {
+			t.Fatalf("section %d: expected %+v, got %+v", i, section, sections[i])
+		}
+	}
+	quoteEntities := sections[1].Entities
+	if len(quoteEntities) != 1 || quoteEntities[0].Type != "bold" || quoteEntities[0].Offset != 0 || quoteEntities[0].Length != 6 {
+		t.Fatalf("expected bold entity rebased onto the quote, got %+v", quoteEntities)
+	}
+}
+
+func TestSectionsInlinePre(t *testing.T) {
+	text := "Run ls -la in the repo root, then check the output."
+	entities := []tgbotapi.MessageEntity{
+		{Type: "pre", Offset: 4, Length: 6},
+		{Type: "italic", Offset: 40, Length: 10},
+	}
+
+	sections := Sections(text, entities)
+	want := []Section{
+		{Kind: SectionText, Text: "Run "},
+		{Kind: SectionCode, Text: "ls -la"},
+		{Kind: SectionText, Text: " in the repo root, then check the output."},
+	}
+	if len(sections) != len(want) {
+		t.Fatalf("expected %d sections, got %+v", len(want), sections)
+	}
+	for i, section := range want {
+		if sections[i].Kind != section.Kind || sections[i].Text != section.Text {
+			t.Fatalf("section %d: expected %+v, got %+v", i, section, sections[i])
+		}
+	}
+	tail := sections[2].Entities
+	if len(tail) != 1 || tail[0].Type != "italic" || tail[0].Offset != 30 || tail[0].Length != 10 {
+		t.Fatalf("expected italic entity rebased onto the trailing text, got %+v", tail)
+	}
+}
@@ -0,0 +1,60 @@
+package tgclient
+
+import (
+	"strings"
+	"unicode/utf16"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Mentions reports whether text mentions the bot, either as @username or as
+// a text mention of its user ID.
+func Mentions(text string, entities []tgbotapi.MessageEntity, username string, userID int64) bool {
+	units := utf16.Encode([]rune(text))
+	for _, entity := range entities {
+		switch entity.Type {
+		case "mention":
+			s, ok := newSpan(units, entity)
+			if ok && username != "" && strings.EqualFold(decodeUnits(units, s.start, s.end), "@"+username) {
+				return true
+			}
+		case "text_mention":
+			if entity.User != nil && entity.User.ID == userID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// StripMention removes a leading @username that addresses the bot, along with
+// the whitespace after it, and shifts the remaining entities to match.
+func StripMention(text string, entities []tgbotapi.MessageEntity, username string) (string, []tgbotapi.MessageEntity) {
+	prefix := "@" + username
+	if username == "" || len(text) < len(prefix) || !strings.EqualFold(text[:len(prefix)], prefix) {
+		return text, entities
+	}
+	rest := text[len(prefix):]
+	if rest != "" && !strings.ContainsAny(rest[:1], " \n\t,:") {
+		return text, entities
+	}
+	rest = strings.TrimLeft(rest, " \n\t,:")
+
+	// The mention and the separators are ASCII, so bytes and UTF-16 units
+	// agree.
+	shift := len(text) - len(rest)
+	shifted := make([]tgbotapi.MessageEntity, 0, len(entities))
+	for _, entity := range entities {
+		end := entity.Offset + entity.Length - shift
+		if end <= 0 {
+			continue
+		}
+		entity.Offset -= shift
+		if entity.Offset < 0 {
+			entity.Offset = 0
+		}
+		entity.Length = end - entity.Offset
+		shifted = append(shifted, entity)
+	}
+	return rest, shifted
+}
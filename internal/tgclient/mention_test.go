@@ -0,0 +1,39 @@
+package tgclient
+
+import (
+	"testing"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestMentions(t *testing.T) {
+	entities := []tgbotapi.MessageEntity{{Type: "mention", Offset: 4, Length: 8}}
+	if !Mentions("hey @NoteBot", entities, "notebot", 1) {
+		t.Error("expected @mention of the bot to match")
+	}
+	if Mentions("hey @OtherBot", entities, "notebot", 1) {
+		t.Error("expected mention of another user not to match")
+	}
+
+	textMention := []tgbotapi.MessageEntity{{Type: "text_mention", Offset: 0, Length: 4, User: &tgbotapi.User{ID: 7}}}
+	if !Mentions("Bot, save this", textMention, "notebot", 7) {
+		t.Error("expected text mention of the bot's user ID to match")
+	}
+}
+
+func TestStripMention(t *testing.T) {
+	text, entities := StripMention("@notebot, see *this*", []tgbotapi.MessageEntity{
+		{Type: "mention", Offset: 0, Length: 8},
+		{Type: "bold", Offset: 14, Length: 6},
+	}, "NoteBot")
+	if text != "see *this*" {
+		t.Fatalf("text = %q", text)
+	}
+	if len(entities) != 1 || entities[0].Type != "bold" || entities[0].Offset != 4 || entities[0].Length != 6 {
+		t.Fatalf("unexpected entities %#v", entities)
+	}
+
+	if text, _ := StripMention("@notebotter hi", nil, "notebot"); text != "@notebotter hi" {
+		t.Fatalf("expected a longer username to be kept, got %q", text)
+	}
+}
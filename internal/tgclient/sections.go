@@ -0,0 +1,97 @@
+package tgclient
+
+import (
+	"sort"
+	"unicode/utf16"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Section kinds returned by Sections.
+const (
+	SectionText  = "text"
+	SectionQuote = "quote"
+	SectionCode  = "code"
+)
+
+// Section is a part of a message that maps to one kind of Notion block.
+// Entities are relative to Text.
+type Section struct {
+	Kind     string
+	Text     string
+	Entities []tgbotapi.MessageEntity
+	Language string
+}
+
+// Sections splits a message at its pre and blockquote entities so each can
+// become a code or quote block, with the text around them kept as plain text
+// sections. A pre entity is split out wherever it sits, even in the middle of
+// a line; inline code is the separate code entity. Newlines at the edges of a
+// section are dropped.
+func Sections(text string, entities []tgbotapi.MessageEntity) []Section {
+	if text == "" {
+		return nil
+	}
+
+	units := utf16.Encode([]rune(text))
+	blocks := make([]span, 0)
+	for _, entity := range entities {
+		switch entity.Type {
+		case "pre", "blockquote", "expandable_blockquote":
+			if s, ok := newSpan(units, entity); ok {
+				blocks = append(blocks, s)
+			}
+		}
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		return blocks[i].start < blocks[j].start
+	})
+
+	sections := make([]Section, 0, 2*len(blocks)+1)
+	cursor := 0
+	for _, block := range blocks {
+		if block.start < cursor {
+			// Block entities do not nest; keep the outer one.
+			continue
+		}
+		sections = appendSection(sections, units, entities, cursor, block.start, SectionText, "")
+		if block.entity.Type == "pre" {
+			sections = appendSection(sections, units, entities, block.start, block.end, SectionCode, block.entity.Language)
+		} else {
+			sections = appendSection(sections, units, entities, block.start, block.end, SectionQuote, "")
+		}
+		cursor = block.end
+	}
+	sections = appendSection(sections, units, entities, cursor, len(units), SectionText, "")
+
+	return sections
+}
+
+func appendSection(sections []Section, units []uint16, entities []tgbotapi.MessageEntity, start int, end int, kind string, language string) []Section {
+	for start < end && units[start] == '\n' {
+		start++
+	}
+	for end > start && units[end-1] == '\n' {
+		end--
+	}
+	if start >= end {
+		return sections
+	}
+
+	section := Section{Kind: kind, Text: decodeUnits(units, start, end), Language: language}
+	for _, entity := range entities {
+		switch entity.Type {
+		case "pre", "blockquote", "expandable_blockquote":
+			continue
+		}
+		s, ok := newSpan(units, entity)
+		if !ok || s.end <= start || s.start >= end {
+			continue
+		}
+		clipped := entity
+		clipped.Offset = max(s.start, start) - start
+		clipped.Length = min(s.end, end) - start - clipped.Offset
+		section.Entities = append(section.Entities, clipped)
+	}
+	return append(sections, section)
+}
@@ -0,0 +1,51 @@
+package tgclient
+
+import (
+	"encoding/json"
+
+	"github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Update is a Bot API update extended with the forum topic fields that the
+// tgbotapi types predate.
+type Update struct {
+	tgbotapi.Update
+	// Topic is the forum topic the message, edited message or pressed button
+	// was sent in, or 0 outside forum topics.
+	Topic int
+}
+
+type topicFields struct {
+	MessageThreadID int  `json:"message_thread_id"`
+	IsTopicMessage  bool `json:"is_topic_message"`
+}
+
+func (u *Update) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &u.Update); err != nil {
+		return err
+	}
+
+	var raw struct {
+		Message       *topicFields `json:"message"`
+		EditedMessage *topicFields `json:"edited_message"`
+		CallbackQuery *struct {
+			Message *topicFields `json:"message"`
+		} `json:"callback_query"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	// Replies in ordinary supergroups carry a thread ID too; only forum
+	// topics split a chat.
+	candidates := []*topicFields{raw.Message, raw.EditedMessage}
+	if raw.CallbackQuery != nil {
+		candidates = append(candidates, raw.CallbackQuery.Message)
+	}
+	for _, fields := range candidates {
+		if fields != nil && fields.IsTopicMessage {
+			u.Topic = fields.MessageThreadID
+		}
+	}
+	return nil
+}
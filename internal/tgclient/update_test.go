@@ -0,0 +1,37 @@
+package tgclient
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestUpdateUnmarshalTopic(t *testing.T) {
+	var topic Update
+	data := `{"update_id":1,"message":{"message_id":5,"message_thread_id":3,"is_topic_message":true,"chat":{"id":-100,"type":"supergroup"},"text":"hi"}}`
+	if err := json.Unmarshal([]byte(data), &topic); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if topic.Topic != 3 || topic.Message == nil || topic.Message.Text != "hi" {
+		t.Fatalf("unexpected update %#v", topic)
+	}
+
+	var reply Update
+	data = `{"update_id":2,"message":{"message_id":6,"message_thread_id":4,"chat":{"id":-100,"type":"supergroup"},"text":"re"}}`
+	if err := json.Unmarshal([]byte(data), &reply); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if reply.Topic != 0 {
+		t.Fatalf("expected reply threads outside forums to be ignored, got topic %d", reply.Topic)
+	}
+}
+
+func TestUpdateUnmarshalCallbackTopic(t *testing.T) {
+	var update Update
+	data := `{"update_id":3,"callback_query":{"id":"q","from":{"id":1},"data":"save","message":{"message_id":7,"message_thread_id":3,"is_topic_message":true,"chat":{"id":-100,"type":"supergroup"}}}}`
+	if err := json.Unmarshal([]byte(data), &update); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if update.Topic != 3 || update.CallbackQuery == nil || update.CallbackQuery.Data != "save" {
+		t.Fatalf("unexpected update %#v", update)
+	}
+}